@@ -0,0 +1,107 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// AppRoleOptions configures an AppRole login. The role_id and secret_id can each be
+// provided as a value, a file or an environment variable, checked in that order.
+type AppRoleOptions struct {
+	// MountPath is the mount of the approle auth method. Defaults to approle.
+	MountPath string
+	// RoleID is the role_id of the AppRole
+	RoleID string
+	// RoleIDFile is a file containing the role_id
+	RoleIDFile string
+	// RoleIDEnv is an environment variable containing the role_id
+	RoleIDEnv string
+	// SecretID is the secret_id of the AppRole
+	SecretID string
+	// SecretIDFile is a file containing the secret_id
+	SecretIDFile string
+	// SecretIDEnv is an environment variable containing the secret_id
+	SecretIDEnv string
+	// WrappedSecretID is true if the secret_id is a response wrapping token
+	// that must be unwrapped before logging in
+	WrappedSecretID bool
+	// Persist stores the resulting token in ~/.vault-token
+	Persist bool
+}
+
+// AppRoleLogin logs in to vault with an AppRole and returns the resulting token
+func (c Config) AppRoleLogin(opts AppRoleOptions) (*Auth, error) {
+	return c.AppRoleLoginContext(context.Background(), opts)
+}
+
+// AppRoleLoginContext is like AppRoleLogin but cancels the requests to vault when the context is done
+func (c Config) AppRoleLoginContext(ctx context.Context, opts AppRoleOptions) (*Auth, error) {
+	roleID, err := secretValue("role_id", opts.RoleID, opts.RoleIDFile, opts.RoleIDEnv)
+	if err != nil {
+		return nil, err
+	}
+	secretID, err := secretValue("secret_id", opts.SecretID, opts.SecretIDFile, opts.SecretIDEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.WrappedSecretID {
+		secretID, err = c.unwrapSecretID(ctx, secretID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	auth, err := c.login(ctx, mountPath(opts.MountPath, "approle")+"/login", map[string]interface{}{
+		"role_id":   roleID,
+		"secret_id": secretID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Persist {
+		if err := PersistToken(auth.ClientToken); err != nil {
+			return nil, err
+		}
+	}
+	return auth, nil
+}
+
+// unwrapSecretID returns the secret_id inside a response wrapping token
+func (c Config) unwrapSecretID(ctx context.Context, wrappingToken string) (string, error) {
+	c.Token = wrappingToken
+	data, err := c.request(ctx, http.MethodPut, "sys/wrapping/unwrap", nil)
+	if err != nil {
+		return "", fmt.Errorf("unwrapping secret_id failed: %w", err)
+	}
+
+	resp := struct {
+		Data struct {
+			SecretID string `json:"secret_id"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return "", fmt.Errorf("error unmarshaling wrapped secret_id: %s", err.Error())
+	}
+	if resp.Data.SecretID == "" {
+		return "", fmt.Errorf("wrapping token did not contain a secret_id")
+	}
+	return resp.Data.SecretID, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_AppRoleLogin(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret-id")
+	assert.NoError(t, ioutil.WriteFile(secretFile, []byte("file-secret\n"), 0600))
+	defer os.Setenv("APPROLE_ROLE_ID", os.Getenv("APPROLE_ROLE_ID"))
+	os.Setenv("APPROLE_ROLE_ID", "env-role")
+
+	loginResponse := fakeResponse{body: `{"auth":{"client_token":"s.token","policies":["default","aws"],"lease_duration":3600,"renewable":true}}`}
+
+	tests := []struct {
+		name      string
+		opts      AppRoleOptions
+		responses map[string]fakeResponse
+		wantPath  string
+		wantData  map[string]interface{}
+		wantErr   bool
+	}{
+		{
+			name:      "values",
+			opts:      AppRoleOptions{RoleID: "role", SecretID: "secret"},
+			responses: map[string]fakeResponse{"POST auth/approle/login": loginResponse},
+			wantPath:  "auth/approle/login",
+			wantData:  map[string]interface{}{"role_id": "role", "secret_id": "secret"},
+		},
+		{
+			name:      "env, file and custom mount",
+			opts:      AppRoleOptions{MountPath: "/ci/", RoleIDEnv: "APPROLE_ROLE_ID", SecretIDFile: secretFile},
+			responses: map[string]fakeResponse{"POST auth/ci/login": loginResponse},
+			wantPath:  "auth/ci/login",
+			wantData:  map[string]interface{}{"role_id": "env-role", "secret_id": "file-secret"},
+		},
+		{
+			name: "wrapped secret id",
+			opts: AppRoleOptions{RoleID: "role", SecretID: "s.wrapping", WrappedSecretID: true},
+			responses: map[string]fakeResponse{
+				"PUT sys/wrapping/unwrap": {body: `{"data":{"secret_id":"unwrapped"}}`},
+				"POST auth/approle/login": loginResponse,
+			},
+			wantPath: "auth/approle/login",
+			wantData: map[string]interface{}{"role_id": "role", "secret_id": "unwrapped"},
+		},
+		{
+			name:    "missing secret id",
+			opts:    AppRoleOptions{RoleID: "role"},
+			wantErr: true,
+		},
+		{
+			name:      "login denied",
+			opts:      AppRoleOptions{RoleID: "role", SecretID: "secret"},
+			responses: map[string]fakeResponse{"POST auth/approle/login": {err: &ResponseError{StatusCode: 400, Errors: []string{"invalid secret id"}}}},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeTransport{responses: tt.responses}
+			c := Config{Transport: fake}
+
+			got, err := c.AppRoleLogin(tt.opts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "s.token", got.ClientToken)
+			assert.Equal(t, []string{"default", "aws"}, got.Policies)
+
+			login := fake.requests[len(fake.requests)-1]
+			assert.Equal(t, tt.wantPath, login.Path)
+			assert.Equal(t, tt.wantData, login.Data)
+			if tt.opts.WrappedSecretID {
+				assert.Equal(t, "s.wrapping", fake.requests[0].Token)
+			}
+		})
+	}
+}
+
+func TestPersistToken(t *testing.T) {
+	home := t.TempDir()
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	fake := &fakeTransport{responses: map[string]fakeResponse{
+		"POST auth/approle/login": {body: `{"auth":{"client_token":"s.persisted"}}`},
+	}}
+	_, err := Config{Transport: fake}.AppRoleLogin(AppRoleOptions{RoleID: "role", SecretID: "secret", Persist: true})
+	assert.NoError(t, err)
+
+	data, err := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+	assert.NoError(t, err)
+	assert.Equal(t, "s.persisted", string(data))
+	assert.Equal(t, "s.persisted", vaultToken())
+}
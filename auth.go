@@ -0,0 +1,124 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// Auth is the token information returned by a vault login.
+// Set the ClientToken as the Token of a Config to use it for later requests.
+type Auth struct {
+	// ClientToken is the vault token
+	ClientToken string `json:"client_token"`
+	// Accessor is the accessor of the token
+	Accessor string `json:"accessor"`
+	// Policies are all the policies attached to the token
+	Policies []string `json:"policies"`
+	// TokenPolicies are the policies attached directly to the token
+	TokenPolicies []string `json:"token_policies"`
+	// Metadata is the metadata set by the auth method
+	Metadata map[string]string `json:"metadata"`
+	// LeaseDuration is the number of seconds the token is valid for
+	LeaseDuration int64 `json:"lease_duration"`
+	// Renewable is true if the token can be renewed
+	Renewable bool `json:"renewable"`
+	// EntityID is the identity entity of the token
+	EntityID string `json:"entity_id"`
+	// TokenType is either service or batch
+	TokenType string `json:"token_type"`
+	// Orphan is true if the token has no parent
+	Orphan bool `json:"orphan"`
+}
+
+// vaultAuthResponse is the response of a vault login
+type vaultAuthResponse struct {
+	Auth *Auth `json:"auth"`
+}
+
+// login writes data to a login endpoint and returns the resulting token
+func (c Config) login(ctx context.Context, path string, data map[string]interface{}) (*Auth, error) {
+	klog.V(3).Infof("attempting vault login at %s", path)
+	resp, err := c.request(ctx, http.MethodPost, path, data)
+	if err != nil {
+		return nil, fmt.Errorf("vault login failed: %w", err)
+	}
+	return parseAuth(resp)
+}
+
+// parseAuth returns the auth section of a vault response
+func parseAuth(data []byte) (*Auth, error) {
+	resp := &vaultAuthResponse{}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, fmt.Errorf("error unmarshaling vault auth: %s", err.Error())
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("vault login did not return a token")
+	}
+	return resp.Auth, nil
+}
+
+// PersistToken stores the token in ~/.vault-token, where the vault CLI and
+// later calls without a Config token will find it
+func PersistToken(token string) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(home, ".vault-token")
+	if err := ioutil.WriteFile(path, []byte(token), 0600); err != nil {
+		return fmt.Errorf("error writing vault token to %s: %w", path, err)
+	}
+	return nil
+}
+
+// mountPath returns the login path of an auth method, using the default mount if none is provided
+func mountPath(mount, defaultMount string) string {
+	mount = strings.Trim(mount, "/")
+	if mount == "" {
+		mount = defaultMount
+	}
+	return fmt.Sprintf("auth/%s", mount)
+}
+
+// secretValue returns the first non-empty value of a literal, the contents of a file, or an environment variable
+func secretValue(name, value, file, env string) (string, error) {
+	if value != "" {
+		return value, nil
+	}
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("error reading %s from %s: %w", name, file, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if env != "" {
+		if v := os.Getenv(env); v != "" {
+			return v, nil
+		}
+		return "", fmt.Errorf("%s environment variable %s is empty", name, env)
+	}
+	return "", fmt.Errorf("no %s provided", name)
+}
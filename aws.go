@@ -15,13 +15,14 @@
 package vaultutil
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -30,13 +31,28 @@ import (
 	"k8s.io/klog"
 )
 
+// Credential types of the vault aws secrets engine, used for Config.AWSCredentialType
+const (
+	// AWSCredentialTypeAuto reads the vault role to find its credential type
+	AWSCredentialTypeAuto = "auto"
+	// AWSCredentialTypeAssumedRole issues sts credentials by assuming an IAM role
+	AWSCredentialTypeAssumedRole = "assumed_role"
+	// AWSCredentialTypeFederationToken issues sts credentials with GetFederationToken
+	AWSCredentialTypeFederationToken = "federation_token"
+	// AWSCredentialTypeIAMUser creates an IAM user with an access key. The credentials
+	// have no session token.
+	AWSCredentialTypeIAMUser = "iam_user"
+	// AWSCredentialTypeSessionToken issues sts credentials with GetSessionToken
+	AWSCredentialTypeSessionToken = "session_token"
+)
+
 // AWSCredentials holds the AWS Credential JSON
 type AWSCredentials struct {
-	// AccessKeyId is the access key ID of the sts credentials
+	// AccessKeyId is the access key ID of the credentials
 	AccessKeyID string `json:"sessionId"`
-	// SecretAccessKey is the secret access key of the sts credentials
+	// SecretAccessKey is the secret access key of the credentials
 	SecretAccessKey string `json:"sessionKey"`
-	// SessionToken is the token of the sts credentials
+	// SessionToken is the token of sts credentials. It is empty for iam_user credentials.
 	SessionToken string `json:"sessionToken"`
 	// Created is the time that the credentials were issued
 	Created time.Time `json:"created,omitempty"`
@@ -44,6 +60,8 @@ type AWSCredentials struct {
 	Duration int64 `json:"duration,omitempty"`
 	// LeaseID is the vault lease id. Can be usd to revoke the credentials
 	LeaseID string `json:"lease_id,omitempty"`
+	// Renewable is true if the vault lease can be renewed, which is the case for iam_user credentials
+	Renewable bool `json:"renewable,omitempty"`
 	// EnvMap is a map of environment variables to the values above. It can be used
 	// to populate necessary CLI environement variables for using the credentials. In
 	// addition, this tool adds the vault lease and the duration/creation in order to
@@ -51,12 +69,16 @@ type AWSCredentials struct {
 	// The environment variables are:
 	//  AWS_ACCESS_KEY_ID=AccessKeyID
 	//  AWS_SECRET_ACCESS_KEY=SecretAccessKey
-	//  AWS_SESSION_TOKEN=SessionToken
-	//  AWS_SECURITY_TOKEN=SessionToken
+	//  AWS_SESSION_TOKEN=SessionToken (only set when not empty)
+	//  AWS_SECURITY_TOKEN=SessionToken (only set when not empty)
 	//  AWS_SESSION_START=Created (in Unix time)
 	//  AWS_SESSION_DURATION=Duration
 	//  AWS_SESSION_VAULT_LEASE_ID=LeaseID
+	//  AWS_SESSION_VAULT_RENEWABLE=true (only set when Renewable)
 	EnvMap map[string]string `json:"environment"`
+
+	// config is the config that issued the credentials, used to revoke them
+	config *Config
 }
 
 type vaultAWSCredentials struct {
@@ -93,6 +115,7 @@ func (a *AWSCredentials) ReadFromEnv() error {
 	a.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
 	a.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
 	a.LeaseID = os.Getenv("AWS_SESSION_VAULT_LEASE_ID")
+	a.Renewable, _ = strconv.ParseBool(os.Getenv("AWS_SESSION_VAULT_RENEWABLE"))
 	a.Created = time.Unix(created, 0)
 	a.Duration = duration
 
@@ -105,7 +128,39 @@ func (a *AWSCredentials) ReadFromEnv() error {
 
 // Revoke revokes the vault lease associated with the credentials
 func (a *AWSCredentials) Revoke() error {
-	return revokeLease(a.LeaseID)
+	return a.RevokeContext(context.Background())
+}
+
+// RevokeContext is like Revoke but cancels the request when the context is done
+func (a *AWSCredentials) RevokeContext(ctx context.Context) error {
+	if a.config != nil {
+		return a.config.revokeLease(ctx, a.LeaseID)
+	}
+	return Config{}.revokeLease(ctx, a.LeaseID)
+}
+
+// Renew extends the vault lease of the credentials by increment seconds, or by the default
+// of the aws secrets engine when increment is zero. Duration, Created and EnvMap are updated
+// from the new lease. Vault may grant less than the increment when the lease nears its max TTL.
+func (a *AWSCredentials) Renew(increment int64) error {
+	return a.RenewContext(context.Background(), increment)
+}
+
+// RenewContext is like Renew but cancels the request when the context is done
+func (a *AWSCredentials) RenewContext(ctx context.Context, increment int64) error {
+	c := Config{}
+	if a.config != nil {
+		c = *a.config
+	}
+	lease, err := c.renewLease(ctx, a.LeaseID, increment)
+	if err != nil {
+		return err
+	}
+
+	a.Created = time.Now()
+	a.Duration = lease.LeaseDuration
+	a.Renewable = lease.Renewable
+	return a.buildEnv()
 }
 
 // buildEnv populates the environment variable of the credentials struct
@@ -127,11 +182,10 @@ func (a *AWSCredentials) buildEnv() error {
 		return fmt.Errorf("cannot set env: secret access key was empty")
 	}
 
+	// iam_user credentials are long lived access keys without a session token
 	if a.SessionToken != "" {
 		a.EnvMap["AWS_SESSION_TOKEN"] = a.SessionToken
 		a.EnvMap["AWS_SECURITY_TOKEN"] = a.SessionToken
-	} else {
-		return fmt.Errorf("cannot set env: session token was empty")
 	}
 
 	if a.LeaseID != "" {
@@ -139,6 +193,9 @@ func (a *AWSCredentials) buildEnv() error {
 	} else {
 		return fmt.Errorf("cannot set env: vault lease id was empty")
 	}
+	if a.Renewable {
+		a.EnvMap["AWS_SESSION_VAULT_RENEWABLE"] = "true"
+	}
 	a.EnvMap["AWS_SESSION_DURATION"] = strconv.FormatInt(a.Duration, 10)
 	a.EnvMap["AWS_SESSION_START"] = strconv.FormatInt(a.Created.Unix(), 10)
 
@@ -146,118 +203,256 @@ func (a *AWSCredentials) buildEnv() error {
 	return nil
 }
 
-// NewAWSCredentials returns existing ones from env if they are not expired
-// if they are expired, or if we can't get any from env, return a new set
+// NewAWSCredentials returns existing ones from env, or from the profile in Config.AWSProfile,
+// if they are not expired. If they are expired, or if we can't get any, return a new set.
+// Expired credentials with a renewable lease are renewed instead when vault
+// extends the lease past the buffer.
 func (c Config) NewAWSCredentials() (*AWSCredentials, error) {
-	creds := &AWSCredentials{}
-	err := creds.ReadFromEnv()
+	return c.NewAWSCredentialsContext(context.Background())
+}
+
+// NewAWSCredentialsContext is like NewAWSCredentials but cancels the request to vault when the context is done
+func (c Config) NewAWSCredentialsContext(ctx context.Context) (*AWSCredentials, error) {
+	creds, err := c.existingAWSCredentials()
 	if err == nil {
-		klog.V(3).Infof("credentials found in environment - checking expiration")
-		if creds.Expired(c.BufferSeconds) {
-			klog.V(3).Info("credentials were expired - getting new ones")
-			newCreds, err := c.AWSLogin()
-			if err != nil {
+		klog.V(3).Infof("existing credentials found - checking expiration")
+		if !creds.Expired(c.BufferSeconds) {
+			klog.V(3).Infof("credentials were valid - returning them")
+			return creds, nil
+		}
+		if c.renewAWSCredentials(ctx, creds) {
+			if err := c.saveAWSCredentials(creds); err != nil {
 				return nil, err
 			}
-			return newCreds, nil
+			return creds, nil
 		}
-		klog.V(3).Infof("credentials were valid - returning them")
-		return creds, nil
+		klog.V(3).Info("credentials were expired - getting new ones")
+	} else {
+		klog.V(3).Infof("error getting existing credentials: %s", err.Error())
+		klog.V(2).Info("no existing credentials found - getting new ones")
 	}
-	klog.V(3).Infof("error getting credentials from env: %s", err.Error())
-	klog.V(2).Info("no existing credentials found - getting new ones")
 
-	newCreds, err := c.AWSLogin()
+	newCreds, err := c.AWSLoginContext(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if err := c.saveAWSCredentials(newCreds); err != nil {
+		return nil, err
+	}
 	return newCreds, nil
 }
 
-// BuildConsoleLogin returns a new console login
+// existingAWSCredentials reads previously issued credentials from the config's profile,
+// or from the environment when no profile is set. They are returned
+// with the config attached, so they are revoked and renewed with its namespace, TLS and token
+func (c Config) existingAWSCredentials() (*AWSCredentials, error) {
+	creds := &AWSCredentials{}
+	if c.AWSProfile != "" {
+		var err error
+		if creds, err = ReadAWSProfile("", c.AWSProfile); err != nil {
+			return nil, err
+		}
+	} else if err := creds.ReadFromEnv(); err != nil {
+		return nil, err
+	}
+	creds.config = &c
+	return creds, nil
+}
+
+// saveAWSCredentials writes the credentials to the config's profile, if any
+func (c Config) saveAWSCredentials(creds *AWSCredentials) error {
+	if c.AWSProfile == "" {
+		return nil
+	}
+	return creds.WriteProfile("", c.AWSProfile)
+}
+
+// renewAWSCredentials tries to renew the lease of expired credentials, and returns true
+// if they are valid for longer than the buffer afterwards
+func (c Config) renewAWSCredentials(ctx context.Context, creds *AWSCredentials) bool {
+	if !creds.Renewable {
+		return false
+	}
+	creds.config = &c
+	if err := creds.RenewContext(ctx, 0); err != nil {
+		klog.V(3).Infof("unable to renew credentials: %s", err.Error())
+		return false
+	}
+	if creds.Expired(c.BufferSeconds) {
+		klog.V(3).Info("credentials reached their max ttl")
+		return false
+	}
+	klog.V(3).Infof("renewed credentials lease %s", creds.LeaseID)
+	return true
+}
+
+// DefaultConsoleIssuer is the issuer of console logins when ConsoleLoginOptions.Issuer is not set
+const DefaultConsoleIssuer = "https://github.com/fairwindsops/vault-util"
+
+// ConsoleLoginOptions are the options of ConsoleLogin
+type ConsoleLoginOptions struct {
+	// Destination is the console page the URL opens. It can be a full URL, or a path or service name
+	// such as ec2/home or s3 that is appended to the console of the config's partition. Defaults to the
+	// console home page.
+	Destination string
+	// Region adds a region parameter to the destination, e.g. us-west-2
+	Region string
+	// Issuer is the URL users are sent to when the console session expires. Defaults to DefaultConsoleIssuer.
+	Issuer string
+	// SessionDuration is how long the console session lasts, between 15 minutes and 12 hours.
+	// AWS uses 12 hours when zero. It cannot be used with federation_token credentials.
+	SessionDuration time.Duration
+	// Credentials are used to sign in. Defaults to the credentials in the environment, or in the
+	// profile of AWS_PROFILE in AWS_SHARED_CREDENTIALS_FILE.
+	Credentials *AWSCredentials
+	// FederationURL is the AWS federation endpoint. Defaults to https://signin.<AWSBaseURL>/federation.
+	FederationURL string
+	// Open is called with the URL, e.g. OpenBrowser to open it in the default browser
+	Open func(url string) error
+}
+
+// BuildConsoleLogin returns a URL that signs in to the AWS console with the current credentials
 func (c Config) BuildConsoleLogin() (string, error) {
-	creds, err := c.getAWSCredentials()
-	if err != nil {
-		return "", err
+	return c.BuildConsoleLoginContext(context.Background())
+}
+
+// BuildConsoleLoginContext is like BuildConsoleLogin but cancels the federation request when the context is done
+func (c Config) BuildConsoleLoginContext(ctx context.Context) (string, error) {
+	return c.ConsoleLoginContext(ctx, ConsoleLoginOptions{})
+}
+
+// ConsoleLogin returns a URL that signs in to the AWS console, configured by the options
+func (c Config) ConsoleLogin(opts ConsoleLoginOptions) (string, error) {
+	return c.ConsoleLoginContext(context.Background(), opts)
+}
+
+// ConsoleLoginContext is like ConsoleLogin but cancels the federation request when the context is done
+func (c Config) ConsoleLoginContext(ctx context.Context, opts ConsoleLoginOptions) (string, error) {
+	if opts.SessionDuration != 0 && (opts.SessionDuration < 15*time.Minute || opts.SessionDuration > 12*time.Hour) {
+		return "", fmt.Errorf("console session duration must be between 15 minutes and 12 hours")
+	}
+	if opts.FederationURL == "" {
+		opts.FederationURL = fmt.Sprintf("https://signin.%s/federation", c.AWSBaseURL)
+	}
+
+	creds := opts.Credentials
+	if creds == nil {
+		var err error
+		creds, err = c.getAWSCredentials()
+		if err != nil {
+			return "", err
+		}
+	}
+	if creds.SessionToken == "" {
+		return "", fmt.Errorf("console login requires temporary credentials with a session token")
 	}
 
-	token, err := c.getSigninToken(creds)
+	token, err := c.getSigninToken(ctx, creds, opts)
 	if err != nil {
 		return "", err
 	}
 
 	klog.V(9).Info(token)
 
-	url, err := c.generateSigninURL(token)
-	if err != nil {
-		return "", err
+	url := c.generateSigninURL(token, opts)
+	if opts.Open != nil {
+		if err := opts.Open(url); err != nil {
+			return url, fmt.Errorf("error opening console login: %w", err)
+		}
 	}
-	fmt.Println(url)
-
-	return "", nil
+	return url, nil
 }
 
 // getSigninToken gets a federation token for signin
-func (c Config) getSigninToken(creds *AWSCredentials) (*string, error) {
-	credsData, err := json.Marshal(creds)
+func (c Config) getSigninToken(ctx context.Context, creds *AWSCredentials, opts ConsoleLoginOptions) (string, error) {
+	// only the keys are sent, not the vault lease or environment of the credentials
+	credsData, err := json.Marshal(map[string]string{
+		"sessionId":    creds.AccessKeyID,
+		"sessionKey":   creds.SecretAccessKey,
+		"sessionToken": creds.SessionToken,
+	})
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	klog.V(4).Info(string(credsData))
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://signin.%s/federation", c.AWSBaseURL), nil)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", opts.FederationURL, nil)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	q := req.URL.Query()
 	q.Add("Action", "getSigninToken")
 	q.Add("Session", string(credsData))
+	if opts.SessionDuration != 0 {
+		q.Add("SessionDuration", strconv.FormatInt(int64(opts.SessionDuration/time.Second), 10))
+	}
 	req.URL.RawQuery = q.Encode()
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, err
+		return "", timeoutError(ctx, "get signin token", err)
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("get signin token failed with code: %d", resp.StatusCode)
+		return "", fmt.Errorf("get signin token failed with code: %d", resp.StatusCode)
 	}
 
-	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	klog.V(10).Info(string(body))
 
 	var respParsed map[string]string
 
-	err = json.Unmarshal([]byte(body), &respParsed)
+	err = json.Unmarshal(body, &respParsed)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	token, ok := respParsed["SigninToken"]
 	if !ok {
-		return nil, fmt.Errorf("could not get signin token from body")
+		return "", fmt.Errorf("could not get signin token from body")
 	}
 
-	return &token, nil
+	return token, nil
 }
 
 // generateSigninURL returns a string that is the url to sign in to the console
-func (c Config) generateSigninURL(token *string) (string, error) {
-	dest := url.PathEscape(fmt.Sprintf("https://console.%s/", c.AWSBaseURL))
-	issuer := url.PathEscape("https://github.com/fairwindsops/vault-util")
+func (c Config) generateSigninURL(token string, opts ConsoleLoginOptions) string {
+	issuer := opts.Issuer
+	if issuer == "" {
+		issuer = DefaultConsoleIssuer
+	}
 
-	url := fmt.Sprintf("https://signin.%s/federation"+
-		"?Action=login"+
-		"&Issuer=%s"+
-		"&Destination=%s"+
-		"&SigninToken=%s",
-		c.AWSBaseURL, issuer, dest, *token)
+	q := url.Values{}
+	q.Set("Action", "login")
+	q.Set("Issuer", issuer)
+	q.Set("Destination", c.consoleDestination(opts))
+	q.Set("SigninToken", token)
+	return fmt.Sprintf("%s?%s", opts.FederationURL, q.Encode())
+}
 
-	return url, nil
+// consoleDestination returns the console URL of the destination option
+func (c Config) consoleDestination(opts ConsoleLoginOptions) string {
+	dest := opts.Destination
+	if !strings.HasPrefix(dest, "https://") {
+		dest = fmt.Sprintf("https://console.%s/%s", c.AWSBaseURL, strings.TrimPrefix(dest, "/"))
+	}
+	if opts.Region == "" {
+		return dest
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		return dest
+	}
+	q := u.Query()
+	q.Set("region", opts.Region)
+	u.RawQuery = q.Encode()
+	return u.String()
 }
 
 // getAWSCredentials retrieves the AWS credentials from the current environment
@@ -289,20 +484,37 @@ func (c Config) getAWSCredentials() (*AWSCredentials, error) {
 	return ret, nil
 }
 
-// AWSLogin calls vault write on an sts endpoint and generates the necessary environment variables
+// AWSLogin requests credentials from the vault aws secrets engine and generates the necessary
+// environment variables. The endpoint depends on the credential type of the role, see
+// Config.AWSCredentialType.
 func (c Config) AWSLogin() (*AWSCredentials, error) {
-	endpoint := fmt.Sprintf("%s/sts/%s", c.Path, c.Role)
+	return c.AWSLoginContext(context.Background())
+}
+
+// AWSLoginContext is like AWSLogin but cancels the request to vault when the context is done
+func (c Config) AWSLoginContext(ctx context.Context) (*AWSCredentials, error) {
+	credentialType, err := c.awsCredentialType(ctx)
+	if err != nil {
+		return nil, err
+	}
+	method, endpoint := c.awsEndpoint(credentialType)
 	klog.V(3).Infof("attempting to get aws credentials from vault at %s", endpoint)
 
-	cmd := exec.Command("vault", "write", endpoint, "-format=json")
-	if c.TTL != "" {
-		cmd.Args = append(cmd.Args, fmt.Sprintf("ttl=%s", c.TTL))
+	params, err := c.awsParams(credentialType)
+	if err != nil {
+		return nil, err
 	}
 
-	data, err := cmd.CombinedOutput()
+	var data []byte
+	if credentialType == AWSCredentialTypeIAMUser {
+		// every iam_user request creates a new IAM user, so it is only retried when vault did not process it
+		data, err = c.request(ctx, method, endpoint, params)
+	} else {
+		// sts credentials do not create any resources in AWS, so failed requests can safely be retried
+		data, err = c.idempotentRequest(ctx, method, endpoint, params)
+	}
 	if err != nil {
-		output := strings.TrimSpace(string(data))
-		return nil, fmt.Errorf("vault aws credentials failed with status %d: %s", cmd.ProcessState.ExitCode(), output)
+		return nil, fmt.Errorf("vault aws credentials failed: %w", err)
 	}
 
 	creds := &vaultAWSCredentials{}
@@ -318,6 +530,8 @@ func (c Config) AWSLogin() (*AWSCredentials, error) {
 		Created:         time.Now(),
 		Duration:        creds.LeaseDuration,
 		LeaseID:         creds.LeaseID,
+		Renewable:       creds.Renewable,
+		config:          &c,
 	}
 
 	klog.V(10).Infof("got credentials: %v", ret)
@@ -327,3 +541,98 @@ func (c Config) AWSLogin() (*AWSCredentials, error) {
 	}
 	return ret, nil
 }
+
+var (
+	roleARNRegexp         = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/[\w+=,.@/-]+$`)
+	roleSessionNameRegexp = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+	mfaCodeRegexp         = regexp.MustCompile(`^\d{6}$`)
+)
+
+// awsParams validates the request options of the config against the credential type
+// and returns them as the parameters of the credentials request
+func (c Config) awsParams(credentialType string) (map[string]interface{}, error) {
+	params := map[string]interface{}{}
+	// iam_user credentials do not expire, so vault rejects a ttl for them
+	if c.TTL != "" && credentialType != AWSCredentialTypeIAMUser {
+		params["ttl"] = c.TTL
+	}
+
+	// vault only accepts role_arn and role_session_name for assumed_role credentials. When the
+	// type is not set it is left to vault, which serves both sts types on the same endpoint.
+	assumedRole := credentialType == "" || credentialType == AWSCredentialTypeAssumedRole
+	if c.RoleARN != "" {
+		if !assumedRole {
+			return nil, fmt.Errorf("role arn can only be used with %s credentials", AWSCredentialTypeAssumedRole)
+		}
+		if !roleARNRegexp.MatchString(c.RoleARN) {
+			return nil, fmt.Errorf("invalid role arn %q", c.RoleARN)
+		}
+		params["role_arn"] = c.RoleARN
+	}
+	if c.RoleSessionName != "" {
+		if !assumedRole {
+			return nil, fmt.Errorf("role session name can only be used with %s credentials", AWSCredentialTypeAssumedRole)
+		}
+		if !roleSessionNameRegexp.MatchString(c.RoleSessionName) {
+			return nil, fmt.Errorf("invalid role session name %q: must be 2 to 64 letters, digits or any of +=,.@_-", c.RoleSessionName)
+		}
+		params["role_session_name"] = c.RoleSessionName
+	}
+	if c.MFACode != "" {
+		if credentialType != AWSCredentialTypeSessionToken {
+			return nil, fmt.Errorf("mfa code can only be used with %s credentials", AWSCredentialTypeSessionToken)
+		}
+		if !mfaCodeRegexp.MatchString(c.MFACode) {
+			return nil, fmt.Errorf("invalid mfa code: must be 6 digits")
+		}
+		params["mfa_code"] = c.MFACode
+	}
+	return params, nil
+}
+
+// awsCredentialType returns the credential type of the config's role. When it is set to
+// AWSCredentialTypeAuto, the role is read from vault.
+func (c Config) awsCredentialType(ctx context.Context) (string, error) {
+	switch c.AWSCredentialType {
+	case "", AWSCredentialTypeAssumedRole, AWSCredentialTypeFederationToken, AWSCredentialTypeIAMUser, AWSCredentialTypeSessionToken:
+		return c.AWSCredentialType, nil
+	case AWSCredentialTypeAuto:
+	default:
+		return "", fmt.Errorf("unknown aws credential type %q", c.AWSCredentialType)
+	}
+
+	endpoint := fmt.Sprintf("%s/roles/%s", c.Path, c.Role)
+	data, err := c.idempotentRequest(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("vault aws role lookup failed: %w", err)
+	}
+
+	role := struct {
+		Data struct {
+			CredentialType string `json:"credential_type"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(data, &role); err != nil {
+		return "", fmt.Errorf("error unmarshaling vault aws role: %s", err.Error())
+	}
+
+	// roles created before vault 1.0 may list several types, in which case the first one is used
+	credentialType := strings.TrimSpace(strings.Split(role.Data.CredentialType, ",")[0])
+	switch credentialType {
+	case AWSCredentialTypeAssumedRole, AWSCredentialTypeFederationToken, AWSCredentialTypeIAMUser, AWSCredentialTypeSessionToken:
+		klog.V(3).Infof("vault aws role %s has credential type %s", c.Role, credentialType)
+		return credentialType, nil
+	}
+	return "", fmt.Errorf("vault aws role %s has unsupported credential type %q", c.Role, role.Data.CredentialType)
+}
+
+// awsEndpoint returns the method and path used to issue credentials of the given type.
+// iam_user and session_token credentials are read from the creds endpoint, while the other
+// types are written to the sts endpoint.
+func (c Config) awsEndpoint(credentialType string) (string, string) {
+	switch credentialType {
+	case AWSCredentialTypeIAMUser, AWSCredentialTypeSessionToken:
+		return http.MethodGet, fmt.Sprintf("%s/creds/%s", c.Path, c.Role)
+	}
+	return http.MethodPost, fmt.Sprintf("%s/sts/%s", c.Path, c.Role)
+}
@@ -15,6 +15,13 @@
 package vaultutil
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
 	"testing"
 	"time"
 
@@ -89,7 +96,13 @@ func TestAWSCredentials_buildEnv(t *testing.T) {
 				Duration:        30,
 				LeaseID:         "vaultleaseid",
 			},
-			wantErr: true,
+			want: map[string]string{
+				"AWS_ACCESS_KEY_ID":          "SOMEACCESSKEYID",
+				"AWS_SECRET_ACCESS_KEY":      "supersecret",
+				"AWS_SESSION_START":          "1",
+				"AWS_SESSION_VAULT_LEASE_ID": "vaultleaseid",
+				"AWS_SESSION_DURATION":       "30",
+			},
 		},
 		{
 			name: "empty lease id",
@@ -157,3 +170,522 @@ func TestAWSCredentials_Expired(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_AWSLogin(t *testing.T) {
+	tests := []struct {
+		name           string
+		ttl            string
+		credentialType string
+		responses      map[string]fakeResponse
+		want           *AWSCredentials
+		wantData       map[string]interface{}
+		wantErr        bool
+	}{
+		{
+			name: "success",
+			ttl:  "1h",
+			responses: map[string]fakeResponse{
+				"POST aws/sts/admin": {body: `{"lease_id":"aws/sts/admin/123","lease_duration":3600,"data":{"access_key":"AKIA","secret_key":"secret","security_token":"token"}}`},
+			},
+			want: &AWSCredentials{
+				AccessKeyID:     "AKIA",
+				SecretAccessKey: "secret",
+				SessionToken:    "token",
+				Duration:        3600,
+				LeaseID:         "aws/sts/admin/123",
+			},
+			wantData: map[string]interface{}{"ttl": "1h"},
+		},
+		{
+			name:           "iam user",
+			ttl:            "1h",
+			credentialType: AWSCredentialTypeIAMUser,
+			responses: map[string]fakeResponse{
+				"GET aws/creds/admin": {body: `{"lease_id":"aws/creds/admin/123","lease_duration":2764800,"renewable":true,"data":{"access_key":"AKIA","secret_key":"secret","security_token":null}}`},
+			},
+			want: &AWSCredentials{
+				AccessKeyID:     "AKIA",
+				SecretAccessKey: "secret",
+				Duration:        2764800,
+				LeaseID:         "aws/creds/admin/123",
+			},
+			wantData: map[string]interface{}{},
+		},
+		{
+			name:           "session token",
+			ttl:            "1h",
+			credentialType: AWSCredentialTypeSessionToken,
+			responses: map[string]fakeResponse{
+				"GET aws/creds/admin": {body: `{"lease_id":"aws/creds/admin/123","lease_duration":3600,"data":{"access_key":"ASIA","secret_key":"secret","security_token":"token"}}`},
+			},
+			want: &AWSCredentials{
+				AccessKeyID:     "ASIA",
+				SecretAccessKey: "secret",
+				SessionToken:    "token",
+				Duration:        3600,
+				LeaseID:         "aws/creds/admin/123",
+			},
+			wantData: map[string]interface{}{"ttl": "1h"},
+		},
+		{
+			name:           "auto detected iam user",
+			credentialType: AWSCredentialTypeAuto,
+			responses: map[string]fakeResponse{
+				"GET aws/roles/admin": {body: `{"data":{"credential_type":"iam_user","policy_arns":["arn:aws:iam::aws:policy/ReadOnlyAccess"]}}`},
+				"GET aws/creds/admin": {body: `{"lease_id":"aws/creds/admin/123","lease_duration":2764800,"data":{"access_key":"AKIA","secret_key":"secret"}}`},
+			},
+			want: &AWSCredentials{
+				AccessKeyID:     "AKIA",
+				SecretAccessKey: "secret",
+				Duration:        2764800,
+				LeaseID:         "aws/creds/admin/123",
+			},
+			wantData: map[string]interface{}{},
+		},
+		{
+			name:           "auto detected assumed role",
+			credentialType: AWSCredentialTypeAuto,
+			responses: map[string]fakeResponse{
+				"GET aws/roles/admin": {body: `{"data":{"credential_type":"assumed_role","role_arns":["arn:aws:iam::123456789012:role/admin"]}}`},
+				"POST aws/sts/admin":  {body: `{"lease_id":"aws/sts/admin/123","lease_duration":3600,"data":{"access_key":"ASIA","secret_key":"secret","security_token":"token"}}`},
+			},
+			want: &AWSCredentials{
+				AccessKeyID:     "ASIA",
+				SecretAccessKey: "secret",
+				SessionToken:    "token",
+				Duration:        3600,
+				LeaseID:         "aws/sts/admin/123",
+			},
+			wantData: map[string]interface{}{},
+		},
+		{
+			name:           "auto detection failed",
+			credentialType: AWSCredentialTypeAuto,
+			responses:      map[string]fakeResponse{},
+			wantErr:        true,
+		},
+		{
+			name:           "unsupported detected type",
+			credentialType: AWSCredentialTypeAuto,
+			responses: map[string]fakeResponse{
+				"GET aws/roles/admin": {body: `{"data":{"credential_type":"console"}}`},
+			},
+			wantErr: true,
+		},
+		{
+			name:           "unknown type",
+			credentialType: "console",
+			wantErr:        true,
+		},
+		{
+			name: "vault error",
+			responses: map[string]fakeResponse{
+				"POST aws/sts/admin": {err: fmt.Errorf("permission denied")},
+			},
+			wantData: map[string]interface{}{},
+			wantErr:  true,
+		},
+		{
+			name: "invalid json",
+			responses: map[string]fakeResponse{
+				"POST aws/sts/admin": {body: `not json`},
+			},
+			wantData: map[string]interface{}{},
+			wantErr:  true,
+		},
+		{
+			name: "missing credentials",
+			responses: map[string]fakeResponse{
+				"POST aws/sts/admin": {body: `{"lease_id":"aws/sts/admin/123","data":{}}`},
+			},
+			wantData: map[string]interface{}{},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeTransport{responses: tt.responses}
+			c := NewConfig("aws", "admin", "aws", 30)
+			c.TTL = tt.ttl
+			c.AWSCredentialType = tt.credentialType
+			c.Transport = fake
+
+			got, err := c.AWSLogin()
+			if tt.wantData != nil {
+				assert.Equal(t, tt.wantData, fake.requests[len(fake.requests)-1].Data)
+			}
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.AccessKeyID, got.AccessKeyID)
+			assert.Equal(t, tt.want.SecretAccessKey, got.SecretAccessKey)
+			assert.Equal(t, tt.want.SessionToken, got.SessionToken)
+			assert.Equal(t, tt.want.Duration, got.Duration)
+			assert.Equal(t, tt.want.LeaseID, got.LeaseID)
+		})
+	}
+}
+
+func TestConfig_awsParams(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         Config
+		credentialType string
+		want           map[string]interface{}
+		wantErr        string
+	}{
+		{
+			name: "assumed role",
+			config: Config{
+				TTL:             "15m",
+				RoleARN:         "arn:aws:iam::123456789012:role/deploy",
+				RoleSessionName: "jane@example.com",
+			},
+			credentialType: AWSCredentialTypeAssumedRole,
+			want: map[string]interface{}{
+				"ttl":               "15m",
+				"role_arn":          "arn:aws:iam::123456789012:role/deploy",
+				"role_session_name": "jane@example.com",
+			},
+		},
+		{
+			name:   "govcloud role arn on the default sts endpoint",
+			config: Config{RoleARN: "arn:aws-us-gov:iam::123456789012:role/path/deploy"},
+			want:   map[string]interface{}{"role_arn": "arn:aws-us-gov:iam::123456789012:role/path/deploy"},
+		},
+		{
+			name:           "session token with mfa",
+			config:         Config{TTL: "1h", MFACode: "123456"},
+			credentialType: AWSCredentialTypeSessionToken,
+			want:           map[string]interface{}{"ttl": "1h", "mfa_code": "123456"},
+		},
+		{
+			name:           "invalid role arn",
+			config:         Config{RoleARN: "arn:aws:iam::123456789012:user/deploy"},
+			credentialType: AWSCredentialTypeAssumedRole,
+			wantErr:        "invalid role arn",
+		},
+		{
+			name:           "invalid role session name",
+			config:         Config{RoleSessionName: "jane doe"},
+			credentialType: AWSCredentialTypeAssumedRole,
+			wantErr:        "invalid role session name",
+		},
+		{
+			name:           "role arn with iam user",
+			config:         Config{RoleARN: "arn:aws:iam::123456789012:role/deploy"},
+			credentialType: AWSCredentialTypeIAMUser,
+			wantErr:        "role arn can only be used with assumed_role credentials",
+		},
+		{
+			name:           "role arn with federation token",
+			config:         Config{RoleARN: "arn:aws:iam::123456789012:role/deploy"},
+			credentialType: AWSCredentialTypeFederationToken,
+			wantErr:        "role arn can only be used with assumed_role credentials",
+		},
+		{
+			name:           "role session name with federation token",
+			config:         Config{RoleSessionName: "jane@example.com"},
+			credentialType: AWSCredentialTypeFederationToken,
+			wantErr:        "role session name can only be used with assumed_role credentials",
+		},
+		{
+			name:           "role session name with session token",
+			config:         Config{RoleSessionName: "jane@example.com"},
+			credentialType: AWSCredentialTypeSessionToken,
+			wantErr:        "role session name can only be used with assumed_role credentials",
+		},
+		{
+			name:           "mfa code with assumed role",
+			config:         Config{MFACode: "123456"},
+			credentialType: AWSCredentialTypeAssumedRole,
+			wantErr:        "mfa code can only be used with session_token credentials",
+		},
+		{
+			name:           "invalid mfa code",
+			config:         Config{MFACode: "12345a"},
+			credentialType: AWSCredentialTypeSessionToken,
+			wantErr:        "invalid mfa code",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.awsParams(tt.credentialType)
+			if tt.wantErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestConfig_AWSLogin_invalidParams(t *testing.T) {
+	fake := &fakeTransport{}
+	c := NewConfig("aws", "admin", "aws", 30)
+	c.MFACode = "123456"
+	c.Transport = fake
+
+	_, err := c.AWSLogin()
+	assert.Error(t, err)
+	assert.Empty(t, fake.requests)
+}
+
+func TestAWSCredentials_Revoke(t *testing.T) {
+	fake := &fakeTransport{responses: map[string]fakeResponse{
+		"POST aws/sts/admin":    {body: `{"lease_id":"aws/sts/admin/123","lease_duration":3600,"data":{"access_key":"AKIA","secret_key":"secret","security_token":"token"}}`},
+		"PUT sys/leases/revoke": {body: ``},
+	}}
+	c := NewConfig("aws", "admin", "aws", 30)
+	c.Transport = fake
+
+	creds, err := c.AWSLogin()
+	assert.NoError(t, err)
+	assert.NoError(t, creds.Revoke())
+	assert.Len(t, fake.requests, 2)
+	assert.Equal(t, map[string]interface{}{"lease_id": "aws/sts/admin/123"}, fake.requests[1].Data)
+}
+
+func TestAWSCredentials_Renew(t *testing.T) {
+	fake := &fakeTransport{responses: map[string]fakeResponse{
+		"PUT sys/leases/renew": {body: `{"lease_id":"aws/creds/admin/123","lease_duration":7200,"renewable":true}`},
+	}}
+	creds := &AWSCredentials{
+		AccessKeyID:     "AKIA",
+		SecretAccessKey: "secret",
+		Created:         time.Unix(1, 0),
+		Duration:        3600,
+		LeaseID:         "aws/creds/admin/123",
+		Renewable:       true,
+		config:          &Config{Transport: fake},
+	}
+
+	assert.NoError(t, creds.Renew(7200))
+	assert.Equal(t, map[string]interface{}{"lease_id": "aws/creds/admin/123", "increment": int64(7200)}, fake.requests[0].Data)
+	assert.Equal(t, int64(7200), creds.Duration)
+	assert.False(t, creds.Expired(30))
+	assert.Equal(t, "7200", creds.EnvMap["AWS_SESSION_DURATION"])
+	assert.Equal(t, "true", creds.EnvMap["AWS_SESSION_VAULT_RENEWABLE"])
+	assert.Equal(t, strconv.FormatInt(creds.Created.Unix(), 10), creds.EnvMap["AWS_SESSION_START"])
+}
+
+func TestConfig_NewAWSCredentials_renew(t *testing.T) {
+	tests := []struct {
+		name          string
+		renewable     string
+		responses     map[string]fakeResponse
+		wantAccessKey string
+		wantRequests  []string
+	}{
+		{
+			name:      "renewed",
+			renewable: "true",
+			responses: map[string]fakeResponse{
+				"PUT sys/leases/renew": {body: `{"lease_id":"aws/creds/admin/123","lease_duration":3600,"renewable":true}`},
+			},
+			wantAccessKey: "AKIAOLD",
+			wantRequests:  []string{"PUT sys/leases/renew"},
+		},
+		{
+			name:      "max ttl reached",
+			renewable: "true",
+			responses: map[string]fakeResponse{
+				"PUT sys/leases/renew": {body: `{"lease_id":"aws/creds/admin/123","lease_duration":10,"renewable":true}`},
+				"GET aws/creds/admin":  {body: `{"lease_id":"aws/creds/admin/456","lease_duration":3600,"renewable":true,"data":{"access_key":"AKIANEW","secret_key":"secret"}}`},
+			},
+			wantAccessKey: "AKIANEW",
+			wantRequests:  []string{"PUT sys/leases/renew", "GET aws/creds/admin"},
+		},
+		{
+			name:      "renewal failed",
+			renewable: "true",
+			responses: map[string]fakeResponse{
+				"GET aws/creds/admin": {body: `{"lease_id":"aws/creds/admin/456","lease_duration":3600,"renewable":true,"data":{"access_key":"AKIANEW","secret_key":"secret"}}`},
+			},
+			wantAccessKey: "AKIANEW",
+			wantRequests:  []string{"PUT sys/leases/renew", "GET aws/creds/admin"},
+		},
+		{
+			name: "not renewable",
+			responses: map[string]fakeResponse{
+				"GET aws/creds/admin": {body: `{"lease_id":"aws/creds/admin/456","lease_duration":3600,"data":{"access_key":"AKIANEW","secret_key":"secret"}}`},
+			},
+			wantAccessKey: "AKIANEW",
+			wantRequests:  []string{"GET aws/creds/admin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer setEnv(map[string]string{
+				"AWS_ACCESS_KEY_ID":           "AKIAOLD",
+				"AWS_SECRET_ACCESS_KEY":       "secret",
+				"AWS_SESSION_TOKEN":           "",
+				"AWS_SESSION_START":           strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10),
+				"AWS_SESSION_DURATION":        "3600",
+				"AWS_SESSION_VAULT_LEASE_ID":  "aws/creds/admin/123",
+				"AWS_SESSION_VAULT_RENEWABLE": tt.renewable,
+			})()
+
+			fake := &fakeTransport{responses: tt.responses}
+			c := NewConfig("aws", "admin", "aws", 30)
+			c.AWSCredentialType = AWSCredentialTypeIAMUser
+			c.Transport = fake
+
+			got, err := c.NewAWSCredentials()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantAccessKey, got.AccessKeyID)
+			assert.False(t, got.Expired(c.BufferSeconds))
+
+			var requests []string
+			for _, r := range fake.requests {
+				requests = append(requests, r.Method+" "+r.Path)
+			}
+			assert.Equal(t, tt.wantRequests, requests)
+		})
+	}
+}
+
+// setEnv sets environment variables and returns a function that restores their previous values
+func setEnv(env map[string]string) func() {
+	previous := map[string]*string{}
+	for k, v := range env {
+		if old, ok := os.LookupEnv(k); ok {
+			previous[k] = &old
+		} else {
+			previous[k] = nil
+		}
+		os.Setenv(k, v)
+	}
+	return func() {
+		for k, v := range previous {
+			if v == nil {
+				os.Unsetenv(k)
+			} else {
+				os.Setenv(k, *v)
+			}
+		}
+	}
+}
+
+func TestConfig_ConsoleLogin(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		if query.Get("Action") != "getSigninToken" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"SigninToken":"signin-token"}`))
+	}))
+	defer server.Close()
+
+	creds := &AWSCredentials{
+		AccessKeyID:     "ASIA",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		LeaseID:         "aws/sts/admin/123",
+	}
+
+	tests := []struct {
+		name            string
+		opts            ConsoleLoginOptions
+		wantDestination string
+		wantIssuer      string
+		wantDuration    string
+		wantErr         string
+	}{
+		{
+			name:            "defaults",
+			opts:            ConsoleLoginOptions{Credentials: creds},
+			wantDestination: "https://console.aws.amazon.com/",
+			wantIssuer:      DefaultConsoleIssuer,
+		},
+		{
+			name: "service deep link",
+			opts: ConsoleLoginOptions{
+				Credentials:     creds,
+				Destination:     "/ec2/home",
+				Region:          "us-west-2",
+				Issuer:          "https://example.com/login",
+				SessionDuration: time.Hour,
+			},
+			wantDestination: "https://console.aws.amazon.com/ec2/home?region=us-west-2",
+			wantIssuer:      "https://example.com/login",
+			wantDuration:    "3600",
+		},
+		{
+			name: "full destination url",
+			opts: ConsoleLoginOptions{
+				Credentials: creds,
+				Destination: "https://us-east-1.console.aws.amazon.com/s3/buckets",
+			},
+			wantDestination: "https://us-east-1.console.aws.amazon.com/s3/buckets",
+			wantIssuer:      DefaultConsoleIssuer,
+		},
+		{
+			name:    "session duration too long",
+			opts:    ConsoleLoginOptions{Credentials: creds, SessionDuration: 13 * time.Hour},
+			wantErr: "between 15 minutes and 12 hours",
+		},
+		{
+			name:    "iam user credentials",
+			opts:    ConsoleLoginOptions{Credentials: &AWSCredentials{AccessKeyID: "AKIA", SecretAccessKey: "secret"}},
+			wantErr: "session token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query = nil
+			var opened string
+			tt.opts.FederationURL = server.URL
+			tt.opts.Open = func(u string) error {
+				opened = u
+				return nil
+			}
+
+			c := NewConfig("aws", "admin", "aws", 30)
+			got, err := c.ConsoleLogin(tt.opts)
+			if tt.wantErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				assert.Nil(t, query)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, got, opened)
+
+			session := map[string]string{}
+			assert.NoError(t, json.Unmarshal([]byte(query.Get("Session")), &session))
+			assert.Equal(t, map[string]string{"sessionId": "ASIA", "sessionKey": "secret", "sessionToken": "token"}, session)
+			assert.Equal(t, tt.wantDuration, query.Get("SessionDuration"))
+
+			u, err := url.Parse(got)
+			assert.NoError(t, err)
+			assert.Equal(t, server.URL, fmt.Sprintf("%s://%s", u.Scheme, u.Host))
+			assert.Equal(t, "login", u.Query().Get("Action"))
+			assert.Equal(t, "signin-token", u.Query().Get("SigninToken"))
+			assert.Equal(t, tt.wantIssuer, u.Query().Get("Issuer"))
+			assert.Equal(t, tt.wantDestination, u.Query().Get("Destination"))
+		})
+	}
+}
+
+func TestConfig_ConsoleLogin_openError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"SigninToken":"signin-token"}`))
+	}))
+	defer server.Close()
+
+	c := NewConfig("gov", "admin", "aws", 30)
+	got, err := c.ConsoleLogin(ConsoleLoginOptions{
+		Credentials:   &AWSCredentials{AccessKeyID: "ASIA", SecretAccessKey: "secret", SessionToken: "token"},
+		FederationURL: server.URL,
+		Open:          func(string) error { return fmt.Errorf("no browser") },
+	})
+	assert.Error(t, err)
+	assert.Contains(t, got, url.QueryEscape("https://console.amazonaws-us-gov.com/"))
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// AWSIAMOptions configures a login with the iam type of the vault aws auth method
+type AWSIAMOptions struct {
+	// Role is the vault role to log in with. Vault uses the name of the IAM principal when empty.
+	Role string
+	// MountPath is the mount of the aws auth method. Defaults to aws.
+	MountPath string
+	// ServerID is sent as the X-Vault-AWS-IAM-Server-ID header, if the auth method requires it
+	ServerID string
+	// Region is the STS region the request is signed for. Defaults to us-east-1, or
+	// us-gov-west-1 when the config uses GovCloud, which use the global STS endpoint.
+	Region string
+	// Credentials are used to sign the request. Defaults to the aws-sdk-go credential chain,
+	// which includes the environment, shared credentials and EC2 and ECS roles.
+	Credentials *credentials.Credentials
+}
+
+// AWSIAMLogin logs in to vault with the IAM identity of the current AWS credentials
+// by sending a signed sts:GetCallerIdentity request to the aws auth method
+func (c Config) AWSIAMLogin(opts AWSIAMOptions) (*Auth, error) {
+	return c.AWSIAMLoginContext(context.Background(), opts)
+}
+
+// AWSIAMLoginContext is like AWSIAMLogin but cancels the request to vault when the context is done
+func (c Config) AWSIAMLoginContext(ctx context.Context, opts AWSIAMOptions) (*Auth, error) {
+	data, err := c.awsIAMLoginData(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.login(ctx, mountPath(opts.MountPath, "aws")+"/login", data)
+}
+
+// awsIAMLoginData builds and signs the sts:GetCallerIdentity request that vault
+// sends to AWS to verify the identity of the caller
+func (c Config) awsIAMLoginData(ctx context.Context, opts AWSIAMOptions) (map[string]interface{}, error) {
+	region := opts.Region
+	if region == "" {
+		region = c.defaultRegion()
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String(region),
+		Credentials: opts.Credentials,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating aws session: %w", err)
+	}
+
+	req, _ := sts.New(sess).GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+	req.SetContext(ctx)
+	if opts.ServerID != "" {
+		req.HTTPRequest.Header.Set("X-Vault-AWS-IAM-Server-ID", opts.ServerID)
+	}
+	if err := req.Sign(); err != nil {
+		return nil, fmt.Errorf("error signing sts request: %w", err)
+	}
+
+	body, err := ioutil.ReadAll(req.HTTPRequest.Body)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := json.Marshal(req.HTTPRequest.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"iam_http_request_method": req.HTTPRequest.Method,
+		"iam_request_url":         base64.StdEncoding.EncodeToString([]byte(req.HTTPRequest.URL.String())),
+		"iam_request_body":        base64.StdEncoding.EncodeToString(body),
+		"iam_request_headers":     base64.StdEncoding.EncodeToString(headers),
+	}
+	if opts.Role != "" {
+		data["role"] = opts.Role
+	}
+	return data, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_AWSIAMLogin(t *testing.T) {
+	var got map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/auth/aws-prod/login", r.URL.Path)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		_, _ = w.Write([]byte(`{"auth":{"client_token":"s.iam","policies":["aws"]}}`))
+	}))
+	defer server.Close()
+	defer os.Setenv("VAULT_ADDR", os.Getenv("VAULT_ADDR"))
+	os.Setenv("VAULT_ADDR", server.URL)
+
+	auth, err := Config{}.AWSIAMLogin(AWSIAMOptions{
+		Role:        "ci",
+		MountPath:   "aws-prod",
+		ServerID:    "vault.example.com",
+		Credentials: credentials.NewStaticCredentials("AKIAEXAMPLE", "secret", ""),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "s.iam", auth.ClientToken)
+
+	assert.Equal(t, "ci", got["role"])
+	assert.Equal(t, "POST", got["iam_http_request_method"])
+
+	url, err := base64.StdEncoding.DecodeString(got["iam_request_url"])
+	assert.NoError(t, err)
+	assert.Equal(t, "https://sts.amazonaws.com/", string(url))
+
+	body, err := base64.StdEncoding.DecodeString(got["iam_request_body"])
+	assert.NoError(t, err)
+	assert.Equal(t, "Action=GetCallerIdentity&Version=2011-06-15", string(body))
+
+	rawHeaders, err := base64.StdEncoding.DecodeString(got["iam_request_headers"])
+	assert.NoError(t, err)
+	headers := http.Header{}
+	assert.NoError(t, json.Unmarshal(rawHeaders, &headers))
+	assert.Equal(t, "vault.example.com", headers.Get("X-Vault-AWS-IAM-Server-ID"))
+	assert.True(t, strings.HasPrefix(headers.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIAEXAMPLE/"))
+	assert.Contains(t, headers.Get("Authorization"), "x-vault-aws-iam-server-id")
+}
+
+func TestConfig_AWSIAMLogin_noCredentials(t *testing.T) {
+	fake := &fakeTransport{}
+	_, err := Config{Transport: fake}.AWSIAMLogin(AWSIAMOptions{
+		Credentials: credentials.NewStaticCredentials("", "", ""),
+	})
+	assert.Error(t, err)
+	assert.Len(t, fake.requests, 0)
+}
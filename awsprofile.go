@@ -0,0 +1,405 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// profileLockTimeout is how long WriteProfile waits for another process to release the lock
+	profileLockTimeout = 10 * time.Second
+	// profileLockStale is the age after which a lock file is assumed to be left over by a crashed process
+	profileLockStale = time.Minute
+	// profileLockRefresh is how often the modification time of a held lock file is refreshed, so it
+	// never becomes stale while its owner is running
+	profileLockRefresh = profileLockStale / 4
+)
+
+// profileKeys are the keys of a profile managed by WriteProfile, in the order they are written.
+// The aws_* keys are read by the AWS CLI and SDKs, x_security_token_expires is the expiration
+// used by other credential helpers, and the vault_* keys allow the credentials to be reused.
+var profileKeys = []string{
+	"aws_access_key_id",
+	"aws_secret_access_key",
+	"aws_session_token",
+	"x_security_token_expires",
+	"vault_session_start",
+	"vault_session_duration",
+	"vault_lease_id",
+	"vault_lease_renewable",
+}
+
+// sharedCredentialsFile returns the path of the shared credentials file, which is
+// AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials
+func sharedCredentialsFile() (string, error) {
+	if file := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); file != "" {
+		return file, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to find home directory: %w", err)
+	}
+	return filepath.Join(home, ".aws", "credentials"), nil
+}
+
+// WriteProfile writes the credentials into a profile of a shared credentials file, which defaults to
+// AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials when empty. Other profiles, comments and unrelated
+// keys of the profile are preserved. The file is replaced atomically while holding a lock file, so
+// concurrent writers do not lose each other's profiles.
+func (a *AWSCredentials) WriteProfile(file, profile string) error {
+	if profile == "" {
+		return fmt.Errorf("cannot write aws profile: profile name was empty")
+	}
+	if file == "" {
+		var err error
+		file, err = sharedCredentialsFile()
+		if err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return fmt.Errorf("error creating aws credentials directory: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), profileLockTimeout)
+	defer cancel()
+	unlock, err := lockFile(ctx, file+".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var lines []string
+	data, err := ioutil.ReadFile(file)
+	if err == nil {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error reading aws credentials file: %w", err)
+	}
+
+	lines = updateProfile(lines, profile, a.profileValues())
+	if err := writeFileAtomic(file, []byte(strings.Join(lines, "\n")+"\n")); err != nil {
+		return fmt.Errorf("error writing aws credentials file: %w", err)
+	}
+	klog.V(3).Infof("wrote aws credentials to profile %s in %s", profile, file)
+	return nil
+}
+
+// ReadAWSProfile reads credentials written by WriteProfile from a shared credentials file, which defaults
+// to AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials when empty. It fails if the profile is missing or
+// was not written by vaultutil, since the vault lease metadata is needed to know when the credentials expire.
+func ReadAWSProfile(file, profile string) (*AWSCredentials, error) {
+	if file == "" {
+		var err error
+		file, err = sharedCredentialsFile()
+		if err != nil {
+			return nil, err
+		}
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading aws credentials file: %w", err)
+	}
+
+	values, ok := readProfile(strings.Split(string(data), "\n"), profile)
+	if !ok {
+		return nil, fmt.Errorf("aws profile %s not found in %s", profile, file)
+	}
+
+	created, err := strconv.ParseInt(values["vault_session_start"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("aws profile %s has no valid vault_session_start: %w", profile, err)
+	}
+	duration, err := strconv.ParseInt(values["vault_session_duration"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("aws profile %s has no valid vault_session_duration: %w", profile, err)
+	}
+	renewable, _ := strconv.ParseBool(values["vault_lease_renewable"])
+
+	ret := &AWSCredentials{
+		AccessKeyID:     values["aws_access_key_id"],
+		SecretAccessKey: values["aws_secret_access_key"],
+		SessionToken:    values["aws_session_token"],
+		Created:         time.Unix(created, 0),
+		Duration:        duration,
+		LeaseID:         values["vault_lease_id"],
+		Renewable:       renewable,
+	}
+	if err := ret.buildEnv(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// profileValues returns the profile keys of the credentials. Keys with an empty value are removed from the profile.
+func (a *AWSCredentials) profileValues() map[string]string {
+	ret := map[string]string{
+		"aws_access_key_id":        a.AccessKeyID,
+		"aws_secret_access_key":    a.SecretAccessKey,
+		"aws_session_token":        a.SessionToken,
+		"x_security_token_expires": a.Created.Add(time.Duration(a.Duration) * time.Second).UTC().Format(time.RFC3339),
+		"vault_session_start":      strconv.FormatInt(a.Created.Unix(), 10),
+		"vault_session_duration":   strconv.FormatInt(a.Duration, 10),
+		"vault_lease_id":           a.LeaseID,
+	}
+	if a.Renewable {
+		ret["vault_lease_renewable"] = "true"
+	}
+	return ret
+}
+
+// updateProfile sets the managed keys of a profile in the lines of an ini file. Existing keys are replaced
+// in place, new ones are added after the last key of the profile, and the profile is appended if missing.
+func updateProfile(lines []string, profile string, values map[string]string) []string {
+	managed := map[string]bool{}
+	for _, k := range profileKeys {
+		managed[k] = true
+	}
+
+	start := -1
+	for i, line := range lines {
+		if name, ok := sectionName(line); ok && name == profile {
+			start = i
+			break
+		}
+	}
+	if start == -1 {
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		lines = append(lines, fmt.Sprintf("[%s]", profile))
+		for _, k := range profileKeys {
+			if values[k] != "" {
+				lines = append(lines, fmt.Sprintf("%s = %s", k, values[k]))
+			}
+		}
+		return lines
+	}
+
+	end := len(lines)
+	for i := start + 1; i < len(lines); i++ {
+		if _, ok := sectionName(lines[i]); ok {
+			end = i
+			break
+		}
+	}
+
+	written := map[string]bool{}
+	section := []string{}
+	last := 0
+	for _, line := range lines[start+1 : end] {
+		if key, _, ok := keyValue(line); ok && managed[key] {
+			if values[key] == "" || written[key] {
+				continue
+			}
+			line = fmt.Sprintf("%s = %s", key, values[key])
+			written[key] = true
+		}
+		section = append(section, line)
+		if trimmed := strings.TrimSpace(line); trimmed != "" && !isComment(trimmed) {
+			last = len(section)
+		}
+	}
+
+	var added []string
+	for _, k := range profileKeys {
+		if values[k] != "" && !written[k] {
+			added = append(added, fmt.Sprintf("%s = %s", k, values[k]))
+		}
+	}
+
+	ret := append([]string{}, lines[:start+1]...)
+	ret = append(ret, section[:last]...)
+	ret = append(ret, added...)
+	ret = append(ret, section[last:]...)
+	return append(ret, lines[end:]...)
+}
+
+// readProfile returns the keys of a profile in the lines of an ini file
+func readProfile(lines []string, profile string) (map[string]string, bool) {
+	var ret map[string]string
+	for _, line := range lines {
+		if name, ok := sectionName(line); ok {
+			if ret != nil {
+				break
+			}
+			if name == profile {
+				ret = map[string]string{}
+			}
+			continue
+		}
+		if ret == nil {
+			continue
+		}
+		if key, value, ok := keyValue(line); ok {
+			ret[key] = value
+		}
+	}
+	return ret, ret != nil
+}
+
+// sectionName returns the name of an ini section header such as [default]
+func sectionName(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
+		return "", false
+	}
+	return strings.TrimSpace(line[1 : len(line)-1]), true
+}
+
+// keyValue splits an ini line such as "key = value"
+func keyValue(line string) (string, string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || isComment(line) {
+		return "", "", false
+	}
+	parts := strings.SplitN(line, "=", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), true
+}
+
+// isComment returns true for ini comment lines
+func isComment(line string) bool {
+	return strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";")
+}
+
+// lockFile creates a lock file, waiting for another process to release it until the context is done.
+// The lock file holds a random owner id, and its modification time is refreshed while the lock is held,
+// so only a lock file left over by a crashed process becomes older than profileLockStale and is removed.
+// The returned function releases the lock if it is still owned by the caller.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	owner, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			_, err = f.WriteString(owner)
+			f.Close()
+			if err != nil {
+				_ = os.Remove(path)
+				return nil, fmt.Errorf("error writing lock file %s: %w", path, err)
+			}
+			return holdLockFile(path, owner), nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("error creating lock file %s: %w", path, err)
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > profileLockStale {
+			klog.V(2).Infof("removing stale lock file %s", path)
+			removeLockFile(path, lockOwner(path))
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for lock file %s: %w", path, ctx.Err())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// holdLockFile refreshes the modification time of an acquired lock file until the returned function
+// releases it
+func holdLockFile(path, owner string) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(profileLockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if lockOwner(path) != owner {
+					klog.Warningf("lock file %s was taken over by another process", path)
+					return
+				}
+				now := time.Now()
+				if err := os.Chtimes(path, now, now); err != nil {
+					klog.V(3).Infof("unable to refresh lock file %s: %s", path, err.Error())
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		removeLockFile(path, owner)
+	}
+}
+
+// lockOwner returns the owner id written into a lock file, or an empty string if it cannot be read
+func lockOwner(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// removeLockFile removes a lock file if it still belongs to the owner, so a lock taken over by
+// another process is left alone
+func removeLockFile(path, owner string) {
+	if lockOwner(path) != owner {
+		klog.V(3).Infof("not removing lock file %s owned by another process", path)
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		klog.V(3).Infof("unable to remove lock file %s: %s", path, err.Error())
+	}
+}
+
+// writeFileAtomic writes the data to a temporary file in the same directory and renames it over the
+// target, so readers never see a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
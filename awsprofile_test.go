@@ -0,0 +1,230 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAWSCredentials_WriteProfile(t *testing.T) {
+	creds := &AWSCredentials{
+		AccessKeyID:     "AKIA",
+		SecretAccessKey: "secret",
+		Created:         time.Unix(1600000000, 0),
+		Duration:        3600,
+		LeaseID:         "aws/creds/admin/123",
+		Renewable:       true,
+	}
+
+	tests := []struct {
+		name     string
+		existing string
+		want     string
+	}{
+		{
+			name: "new file",
+			want: `[vault]
+aws_access_key_id = AKIA
+aws_secret_access_key = secret
+x_security_token_expires = 2020-09-13T13:26:40Z
+vault_session_start = 1600000000
+vault_session_duration = 3600
+vault_lease_id = aws/creds/admin/123
+vault_lease_renewable = true
+`,
+		},
+		{
+			name: "new profile",
+			existing: `# managed by hand
+[default]
+aws_access_key_id = DEFAULT
+`,
+			want: `# managed by hand
+[default]
+aws_access_key_id = DEFAULT
+
+[vault]
+aws_access_key_id = AKIA
+aws_secret_access_key = secret
+x_security_token_expires = 2020-09-13T13:26:40Z
+vault_session_start = 1600000000
+vault_session_duration = 3600
+vault_lease_id = aws/creds/admin/123
+vault_lease_renewable = true
+`,
+		},
+		{
+			name: "existing profile",
+			existing: `[default]
+aws_access_key_id = DEFAULT
+
+[vault]
+; credentials from vault
+region = us-east-1
+aws_access_key_id = OLD
+aws_session_token = OLDTOKEN
+aws_secret_access_key = old
+# end of vault
+
+[other]
+aws_access_key_id = OTHER
+`,
+			want: `[default]
+aws_access_key_id = DEFAULT
+
+[vault]
+; credentials from vault
+region = us-east-1
+aws_access_key_id = AKIA
+aws_secret_access_key = secret
+x_security_token_expires = 2020-09-13T13:26:40Z
+vault_session_start = 1600000000
+vault_session_duration = 3600
+vault_lease_id = aws/creds/admin/123
+vault_lease_renewable = true
+# end of vault
+
+[other]
+aws_access_key_id = OTHER
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), ".aws", "credentials")
+			if tt.existing != "" {
+				assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0700))
+				assert.NoError(t, ioutil.WriteFile(file, []byte(tt.existing), 0600))
+			}
+
+			assert.NoError(t, creds.WriteProfile(file, "vault"))
+			got, err := ioutil.ReadFile(file)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+
+			info, err := os.Stat(file)
+			assert.NoError(t, err)
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+			_, err = os.Stat(file + ".lock")
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestAWSCredentials_WriteProfile_concurrent(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "credentials")
+	profiles := []string{"one", "two", "three", "four", "five"}
+
+	var wg sync.WaitGroup
+	for _, profile := range profiles {
+		wg.Add(1)
+		go func(profile string) {
+			defer wg.Done()
+			creds := &AWSCredentials{AccessKeyID: profile, SecretAccessKey: "secret", LeaseID: "lease", Created: time.Now(), Duration: 60}
+			assert.NoError(t, creds.WriteProfile(file, profile))
+		}(profile)
+	}
+	wg.Wait()
+
+	for _, profile := range profiles {
+		got, err := ReadAWSProfile(file, profile)
+		assert.NoError(t, err)
+		assert.Equal(t, profile, got.AccessKeyID)
+	}
+}
+
+func TestReadAWSProfile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "credentials")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`[manual]
+aws_access_key_id = MANUAL
+aws_secret_access_key = secret
+
+[vault]
+aws_access_key_id=AKIA
+aws_secret_access_key=secret
+aws_session_token=token
+vault_session_start=1600000000
+vault_session_duration=3600
+vault_lease_id=aws/sts/admin/123
+`), 0600))
+
+	got, err := ReadAWSProfile(file, "vault")
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIA", got.AccessKeyID)
+	assert.Equal(t, "token", got.SessionToken)
+	assert.Equal(t, time.Unix(1600000000, 0), got.Created)
+	assert.Equal(t, int64(3600), got.Duration)
+	assert.Equal(t, "aws/sts/admin/123", got.EnvMap["AWS_SESSION_VAULT_LEASE_ID"])
+
+	_, err = ReadAWSProfile(file, "manual")
+	assert.Error(t, err)
+	_, err = ReadAWSProfile(file, "missing")
+	assert.Error(t, err)
+}
+
+func TestConfig_NewAWSCredentials_profile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "credentials")
+	defer setEnv(map[string]string{"AWS_SHARED_CREDENTIALS_FILE": file})()
+
+	fake := &fakeTransport{responses: map[string]fakeResponse{
+		"POST aws/sts/admin": {body: `{"lease_id":"aws/sts/admin/123","lease_duration":3600,"data":{"access_key":"AKIA","secret_key":"secret","security_token":"token"}}`},
+	}}
+	c := NewConfig("aws", "admin", "aws", 30)
+	c.AWSProfile = "vault"
+	c.Transport = fake
+
+	first, err := c.NewAWSCredentials()
+	assert.NoError(t, err)
+	second, err := c.NewAWSCredentials()
+	assert.NoError(t, err)
+	assert.Len(t, fake.requests, 1)
+	assert.Equal(t, first.LeaseID, second.LeaseID)
+	assert.Equal(t, first.Created.Unix(), second.Created.Unix())
+}
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.lock")
+
+	unlock, err := lockFile(context.Background(), path)
+	assert.NoError(t, err)
+
+	// a held lock is waited for until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = lockFile(ctx, path)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "timed out waiting for lock file")
+
+	// a stale lock is taken over, and the previous owner does not remove the new lock
+	old := time.Now().Add(-2 * profileLockStale)
+	assert.NoError(t, os.Chtimes(path, old, old))
+	unlockNew, err := lockFile(context.Background(), path)
+	assert.NoError(t, err)
+	unlock()
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+
+	unlockNew()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
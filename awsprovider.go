@@ -0,0 +1,70 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// AWSProviderName is the provider name of credentials retrieved by AWSProvider
+const AWSProviderName = "VaultProvider"
+
+// AWSProvider is an aws-sdk-go credentials.Provider that issues credentials from vault. The SDK
+// retrieves new credentials once the current ones expire with Config.BufferSeconds, e.g.
+//
+//	sess, err := session.NewSession(&aws.Config{
+//		Credentials: credentials.NewCredentials(config.NewAWSProvider()),
+//	})
+type AWSProvider struct {
+	source *awsCredentialSource
+}
+
+// NewAWSProvider returns a credentials provider for the config's vault aws role
+func (c Config) NewAWSProvider() *AWSProvider {
+	return &AWSProvider{source: newAWSCredentialSource(c)}
+}
+
+// Retrieve returns the current credentials, issuing new ones when they are expired
+func (p *AWSProvider) Retrieve() (credentials.Value, error) {
+	return p.RetrieveWithContext(context.Background())
+}
+
+// RetrieveWithContext is like Retrieve but cancels the request to vault when the context is done
+func (p *AWSProvider) RetrieveWithContext(ctx credentials.Context) (credentials.Value, error) {
+	creds, err := p.source.get(ctx)
+	if err != nil {
+		return credentials.Value{ProviderName: AWSProviderName}, err
+	}
+	return credentials.Value{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		ProviderName:    AWSProviderName,
+	}, nil
+}
+
+// IsExpired returns true if the credentials expire within Config.BufferSeconds
+func (p *AWSProvider) IsExpired() bool {
+	return p.source.expired()
+}
+
+// ExpiresAt returns the time the credentials will be replaced, which is Config.BufferSeconds
+// before their vault lease expires. It implements credentials.Expirer.
+func (p *AWSProvider) ExpiresAt() time.Time {
+	return p.source.expiresAt()
+}
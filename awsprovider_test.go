@@ -0,0 +1,69 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	_ credentials.ProviderWithContext = &AWSProvider{}
+	_ credentials.Expirer             = &AWSProvider{}
+)
+
+func TestAWSProvider(t *testing.T) {
+	c, fake := newSTSConfig(fakeResponse{body: stsResponse})
+	provider := c.NewAWSProvider()
+	creds := credentials.NewCredentials(provider)
+
+	assert.True(t, provider.IsExpired())
+	value, err := creds.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, credentials.Value{
+		AccessKeyID:     "ASIA",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		ProviderName:    AWSProviderName,
+	}, value)
+	assert.False(t, provider.IsExpired())
+	assert.WithinDuration(t, time.Now().Add(3570*time.Second), provider.ExpiresAt(), time.Minute)
+
+	_, err = creds.Get()
+	assert.NoError(t, err)
+	assert.Len(t, fake.requests, 1)
+
+	// move the credentials into the buffer so the sdk asks for new ones
+	provider.source.creds.Created = time.Now().Add(-time.Hour)
+	assert.True(t, creds.IsExpired())
+	_, err = creds.Get()
+	assert.NoError(t, err)
+	assert.Len(t, fake.requests, 2)
+}
+
+func TestAWSProvider_error(t *testing.T) {
+	c, _ := newSTSConfig(fakeResponse{err: fmt.Errorf("permission denied")})
+	provider := c.NewAWSProvider()
+
+	value, err := provider.Retrieve()
+	assert.Error(t, err)
+	assert.Equal(t, AWSProviderName, value.ProviderName)
+	assert.True(t, provider.IsExpired())
+	assert.True(t, provider.ExpiresAt().IsZero())
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"fmt"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// AWSProviderV2 is an aws-sdk-go-v2 aws.CredentialsProvider that issues credentials from vault
+// in the same way as AWSLogin. It is meant to be wrapped in a credentials cache, e.g.
+//
+//	cfg, err := awsconfig.LoadDefaultConfig(ctx,
+//		awsconfig.WithCredentialsProvider(aws.NewCredentialsCache(config.NewAWSProviderV2())),
+//	)
+type AWSProviderV2 struct {
+	source *awsCredentialSource
+}
+
+// NewAWSProviderV2 returns an aws-sdk-go-v2 credentials provider for the config's vault aws role
+func (c Config) NewAWSProviderV2() *AWSProviderV2 {
+	return &AWSProviderV2{source: newAWSCredentialSource(c)}
+}
+
+// Retrieve returns the current credentials, issuing new ones when they are expired. The credentials
+// expire Config.BufferSeconds before their vault lease, and their Source contains the lease ID.
+func (p *AWSProviderV2) Retrieve(ctx context.Context) (awsv2.Credentials, error) {
+	creds, err := p.source.get(ctx)
+	if err != nil {
+		return awsv2.Credentials{}, err
+	}
+	return awsv2.Credentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		Source:          fmt.Sprintf("%s(%s)", AWSProviderName, creds.LeaseID),
+		CanExpire:       true,
+		Expires:         creds.expiresAt(p.source.config.BufferSeconds),
+	}, nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ awsv2.CredentialsProvider = &AWSProviderV2{}
+
+func TestAWSProviderV2(t *testing.T) {
+	c, fake := newSTSConfig(fakeResponse{body: stsResponse})
+	cache := awsv2.NewCredentialsCache(c.NewAWSProviderV2())
+
+	got, err := cache.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "ASIA", got.AccessKeyID)
+	assert.Equal(t, "secret", got.SecretAccessKey)
+	assert.Equal(t, "token", got.SessionToken)
+	assert.Equal(t, "VaultProvider(aws/sts/admin/123)", got.Source)
+	assert.True(t, got.CanExpire)
+	assert.WithinDuration(t, time.Now().Add(3570*time.Second), got.Expires, time.Minute)
+
+	_, err = cache.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, fake.requests, 1)
+}
+
+func TestAWSProviderV2_error(t *testing.T) {
+	c, _ := newSTSConfig(fakeResponse{err: fmt.Errorf("permission denied")})
+
+	_, err := c.NewAWSProviderV2().Retrieve(context.Background())
+	assert.Error(t, err)
+}
@@ -15,12 +15,12 @@
 package vaultutil
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
-	"os/exec"
 	"strconv"
-	"strings"
 	"time"
 
 	"k8s.io/klog"
@@ -39,6 +39,8 @@ type AzureCredentials struct {
 	// LeaseID is the Vault Lease ID of the requested credentials.
 	// This can be used to revoke the lease when the credentials are no longer needed.
 	LeaseID string `json:"lease_id"`
+	// Renewable is true if the vault lease can be renewed
+	Renewable bool `json:"renewable,omitempty"`
 	// EnvMap is a map of environment variables to the values above. It can be used
 	// to populate necessary CLI environement variables for using the credentials. In
 	// addition, this tool adds the vault lease and the duration/creation in order to
@@ -49,7 +51,11 @@ type AzureCredentials struct {
 	//  ARM_SESSION_START=Created (in Unix time)
 	//  ARM_SESSION_DURATION=Duration
 	//  ARM_SESSION_VAULT_LEASE_ID=LeaseID
+	//  ARM_SESSION_VAULT_RENEWABLE=true (only set when Renewable)
 	EnvMap map[string]string `json:"environment"`
+
+	// config is the config that issued the credentials, used to revoke them
+	config *Config
 }
 
 // vaultAzureCredentials is the response from Vault for azure backends
@@ -88,6 +94,7 @@ func (az *AzureCredentials) ReadFromEnv() error {
 	az.ClientID = os.Getenv("ARM_CLIENT_ID")
 	az.ClientSecret = os.Getenv("ARM_CLIENT_SECRET")
 	az.LeaseID = os.Getenv("ARM_SESSION_VAULT_LEASE_ID")
+	az.Renewable, _ = strconv.ParseBool(os.Getenv("ARM_SESSION_VAULT_RENEWABLE"))
 	az.Created = time.Unix(created, 0)
 	az.Duration = duration
 
@@ -100,7 +107,39 @@ func (az *AzureCredentials) ReadFromEnv() error {
 
 // Revoke revokes the vault lease associated with the credentials
 func (az *AzureCredentials) Revoke() error {
-	return revokeLease(az.LeaseID)
+	return az.RevokeContext(context.Background())
+}
+
+// RevokeContext is like Revoke but cancels the request when the context is done
+func (az *AzureCredentials) RevokeContext(ctx context.Context) error {
+	if az.config != nil {
+		return az.config.revokeLease(ctx, az.LeaseID)
+	}
+	return Config{}.revokeLease(ctx, az.LeaseID)
+}
+
+// Renew extends the vault lease of the credentials by increment seconds, or by the default
+// of the azure secrets engine when increment is zero. Duration, Created and EnvMap are updated
+// from the new lease. Vault may grant less than the increment when the lease nears its max TTL.
+func (az *AzureCredentials) Renew(increment int64) error {
+	return az.RenewContext(context.Background(), increment)
+}
+
+// RenewContext is like Renew but cancels the request when the context is done
+func (az *AzureCredentials) RenewContext(ctx context.Context, increment int64) error {
+	c := Config{}
+	if az.config != nil {
+		c = *az.config
+	}
+	lease, err := c.renewLease(ctx, az.LeaseID, increment)
+	if err != nil {
+		return err
+	}
+
+	az.Created = time.Now()
+	az.Duration = lease.LeaseDuration
+	az.Renewable = lease.Renewable
+	return az.buildEnv()
 }
 
 // buildEnv populates the environment variable of the credentials struct
@@ -127,6 +166,9 @@ func (az *AzureCredentials) buildEnv() error {
 	} else {
 		return fmt.Errorf("cannot set env: vault least id was empty")
 	}
+	if az.Renewable {
+		az.EnvMap["ARM_SESSION_VAULT_RENEWABLE"] = "true"
+	}
 	az.EnvMap["ARM_SESSION_DURATION"] = strconv.FormatInt(az.Duration, 10)
 	az.EnvMap["ARM_SESSION_START"] = strconv.FormatInt(az.Created.Unix(), 10)
 
@@ -135,12 +177,24 @@ func (az *AzureCredentials) buildEnv() error {
 
 // NewAzureCredentials returns existing ones from env if they are not expired
 // if they are expired, or if we can't get any from env, return a new set.
+// Expired credentials with a renewable lease are renewed instead when vault
+// extends the lease past the buffer, which avoids creating a new service principal.
 func (c Config) NewAzureCredentials() (*AzureCredentials, error) {
+	return c.NewAzureCredentialsContext(context.Background())
+}
+
+// NewAzureCredentialsContext is like NewAzureCredentials but cancels the request to vault when the context is done
+func (c Config) NewAzureCredentialsContext(ctx context.Context) (*AzureCredentials, error) {
 	creds := &AzureCredentials{}
 	if err := creds.ReadFromEnv(); err == nil {
+		// attach the config so the credentials are revoked and renewed with its namespace, TLS and token
+		creds.config = &c
 		if creds.Expired(c.BufferSeconds) {
+			if c.renewAzureCredentials(ctx, creds) {
+				return creds, nil
+			}
 			klog.V(3).Infof("found expired credentials - getting new ones")
-			newCreds, err := c.AzureLogin()
+			newCreds, err := c.AzureLoginContext(ctx)
 			if err != nil {
 				return nil, err
 			}
@@ -150,7 +204,7 @@ func (c Config) NewAzureCredentials() (*AzureCredentials, error) {
 		return creds, nil
 	}
 	klog.V(3).Infof("unable to retrieve existing credentials - getting new ones")
-	newCreds, err := c.AzureLogin()
+	newCreds, err := c.AzureLoginContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -158,18 +212,40 @@ func (c Config) NewAzureCredentials() (*AzureCredentials, error) {
 	return newCreds, nil
 }
 
+// renewAzureCredentials tries to renew the lease of expired credentials, and returns true
+// if they are valid for longer than the buffer afterwards
+func (c Config) renewAzureCredentials(ctx context.Context, creds *AzureCredentials) bool {
+	if !creds.Renewable {
+		return false
+	}
+	creds.config = &c
+	if err := creds.RenewContext(ctx, 0); err != nil {
+		klog.V(3).Infof("unable to renew credentials: %s", err.Error())
+		return false
+	}
+	if creds.Expired(c.BufferSeconds) {
+		klog.V(3).Info("credentials reached their max ttl")
+		return false
+	}
+	klog.V(3).Infof("renewed credentials lease %s", creds.LeaseID)
+	return true
+}
+
 // AzureLogin calls vault read on a credentials endpoint and generates the necessary environment variables.
 // If no environment variable support is desired, and renewing credentials is not needed, then this function
 // can be used to get just a simple set of credentials.
 func (c Config) AzureLogin() (*AzureCredentials, error) {
+	return c.AzureLoginContext(context.Background())
+}
+
+// AzureLoginContext is like AzureLogin but cancels the request to vault when the context is done
+func (c Config) AzureLoginContext(ctx context.Context) (*AzureCredentials, error) {
 	endpoint := fmt.Sprintf("%s/creds/%s", c.Path, c.Role)
 	klog.V(3).Infof("attempting to get azure credentials from vault at %s", endpoint)
-	cmd := exec.Command("vault", "read", endpoint, "-format=json")
 
-	data, err := cmd.CombinedOutput()
+	data, err := c.request(ctx, http.MethodGet, endpoint, nil)
 	if err != nil {
-		output := strings.TrimSpace(string(data))
-		return nil, fmt.Errorf("vault azure credentials failed with status %d: %s", cmd.ProcessState.ExitCode(), output)
+		return nil, fmt.Errorf("vault azure credentials failed: %w", err)
 	}
 
 	creds := &vaultAzureCredentials{}
@@ -184,6 +260,8 @@ func (c Config) AzureLogin() (*AzureCredentials, error) {
 		Created:      time.Now(),
 		Duration:     creds.LeaseDuration,
 		LeaseID:      creds.LeaseID,
+		Renewable:    creds.Renewable,
+		config:       &c,
 	}
 
 	if err := ret.buildEnv(); err != nil {
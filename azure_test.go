@@ -15,6 +15,8 @@
 package vaultutil
 
 import (
+	"fmt"
+	"strconv"
 	"testing"
 	"time"
 
@@ -137,3 +139,100 @@ func TestAzureCredentials_Expired(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_AzureLogin(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses map[string]fakeResponse
+		want      *AzureCredentials
+		wantErr   bool
+	}{
+		{
+			name: "success",
+			responses: map[string]fakeResponse{
+				"GET azure/creds/admin": {body: `{"lease_id":"azure/creds/admin/123","lease_duration":3600,"data":{"client_id":"id","client_secret":"secret"}}`},
+			},
+			want: &AzureCredentials{
+				ClientID:     "id",
+				ClientSecret: "secret",
+				Duration:     3600,
+				LeaseID:      "azure/creds/admin/123",
+			},
+		},
+		{
+			name: "vault error",
+			responses: map[string]fakeResponse{
+				"GET azure/creds/admin": {err: fmt.Errorf("permission denied")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing lease",
+			responses: map[string]fakeResponse{
+				"GET azure/creds/admin": {body: `{"data":{"client_id":"id","client_secret":"secret"}}`},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConfig("aws", "admin", "azure", 30)
+			c.Transport = &fakeTransport{responses: tt.responses}
+
+			got, err := c.AzureLogin()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.ClientID, got.ClientID)
+			assert.Equal(t, tt.want.ClientSecret, got.ClientSecret)
+			assert.Equal(t, tt.want.Duration, got.Duration)
+			assert.Equal(t, tt.want.LeaseID, got.LeaseID)
+		})
+	}
+}
+
+func TestAzureCredentials_Renew(t *testing.T) {
+	fake := &fakeTransport{responses: map[string]fakeResponse{
+		"PUT sys/leases/renew": {body: `{"lease_id":"azure/creds/admin/123","lease_duration":3600,"renewable":false}`},
+	}}
+	creds := &AzureCredentials{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Created:      time.Unix(1, 0),
+		Duration:     3600,
+		LeaseID:      "azure/creds/admin/123",
+		Renewable:    true,
+		config:       &Config{Transport: fake},
+	}
+
+	assert.NoError(t, creds.Renew(0))
+	assert.Equal(t, map[string]interface{}{"lease_id": "azure/creds/admin/123"}, fake.requests[0].Data)
+	assert.False(t, creds.Expired(30))
+	assert.False(t, creds.Renewable)
+	assert.NotContains(t, creds.EnvMap, "ARM_SESSION_VAULT_RENEWABLE")
+}
+
+func TestConfig_NewAzureCredentials_renew(t *testing.T) {
+	defer setEnv(map[string]string{
+		"ARM_CLIENT_ID":               "id",
+		"ARM_CLIENT_SECRET":           "secret",
+		"ARM_SESSION_START":           strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10),
+		"ARM_SESSION_DURATION":        "3600",
+		"ARM_SESSION_VAULT_LEASE_ID":  "azure/creds/admin/123",
+		"ARM_SESSION_VAULT_RENEWABLE": "true",
+	})()
+
+	fake := &fakeTransport{responses: map[string]fakeResponse{
+		"PUT sys/leases/renew": {body: `{"lease_id":"azure/creds/admin/123","lease_duration":3600,"renewable":true}`},
+	}}
+	c := Config{Path: "azure", Role: "admin", BufferSeconds: 30, Transport: fake}
+
+	got, err := c.NewAzureCredentials()
+	assert.NoError(t, err)
+	assert.Equal(t, "id", got.ClientID)
+	assert.False(t, got.Expired(c.BufferSeconds))
+	assert.Len(t, fake.requests, 1)
+	assert.Equal(t, "sys/leases/renew", fake.requests[0].Path)
+}
@@ -0,0 +1,187 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// revokePath is the vault path used to revoke leases
+	revokePath = "sys/leases/revoke"
+	// renewPath is the vault path used to renew leases
+	renewPath = "sys/leases/renew"
+)
+
+// PathCapabilities are the capabilities of the current token on a single path
+type PathCapabilities struct {
+	// Path is the vault path that was checked
+	Path string
+	// Required are the capabilities needed on the path
+	Required []string
+	// Granted are the capabilities the token has on the path
+	Granted []string
+	// Missing are the required capabilities the token does not have
+	Missing []string
+}
+
+// CapabilityReport is the result of a capability check
+type CapabilityReport struct {
+	// Paths holds the result for every checked path, sorted by path
+	Paths []PathCapabilities
+}
+
+// Allowed returns true if no required capability is missing
+func (r *CapabilityReport) Allowed() bool {
+	return len(r.Missing()) == 0
+}
+
+// Missing returns only the paths with missing capabilities
+func (r *CapabilityReport) Missing() []PathCapabilities {
+	var ret []PathCapabilities
+	for _, p := range r.Paths {
+		if len(p.Missing) > 0 {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// String describes the missing capabilities, e.g. to tell a user which policy to request
+func (r *CapabilityReport) String() string {
+	missing := r.Missing()
+	if len(missing) == 0 {
+		return "all required capabilities are granted"
+	}
+	var parts []string
+	for _, p := range missing {
+		parts = append(parts, fmt.Sprintf("%s on %s", strings.Join(p.Missing, ", "), p.Path))
+	}
+	return fmt.Sprintf("missing vault capabilities: %s", strings.Join(parts, "; "))
+}
+
+// CheckCapabilities asks vault which capabilities the current token has on each path,
+// and reports which of the required capabilities are missing
+func (c Config) CheckCapabilities(required map[string][]string) (*CapabilityReport, error) {
+	return c.CheckCapabilitiesContext(context.Background(), required)
+}
+
+// CheckCapabilitiesContext is like CheckCapabilities but cancels the request to vault when the context is done
+func (c Config) CheckCapabilitiesContext(ctx context.Context, required map[string][]string) (*CapabilityReport, error) {
+	paths := make([]string, 0, len(required))
+	for p := range required {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	data, err := c.idempotentRequest(ctx, http.MethodPost, "sys/capabilities-self", map[string]interface{}{
+		"paths": paths,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("vault capabilities check failed: %w", err)
+	}
+
+	resp := struct {
+		Data map[string][]string `json:"data"`
+	}{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("error unmarshaling vault capabilities: %s", err.Error())
+	}
+
+	report := &CapabilityReport{}
+	for _, p := range paths {
+		granted, ok := resp.Data[p]
+		if !ok {
+			return nil, fmt.Errorf("vault did not return capabilities for %s", p)
+		}
+		report.Paths = append(report.Paths, PathCapabilities{
+			Path:     p,
+			Required: required[p],
+			Granted:  granted,
+			Missing:  missingCapabilities(required[p], granted),
+		})
+	}
+	return report, nil
+}
+
+// CanIssueAWS checks that the current token can request and revoke aws credentials for the config's role
+func (c Config) CanIssueAWS() (*CapabilityReport, error) {
+	return c.CanIssueAWSContext(context.Background())
+}
+
+// CanIssueAWSContext is like CanIssueAWS but cancels the request to vault when the context is done.
+// With AWSCredentialTypeAuto the role is read first to find the endpoint. If the token cannot
+// read the role, the report lists the missing read capability on it instead of the endpoint.
+func (c Config) CanIssueAWSContext(ctx context.Context) (*CapabilityReport, error) {
+	required := map[string][]string{
+		revokePath: {"update"},
+	}
+	if c.AWSCredentialType == AWSCredentialTypeAuto {
+		required[fmt.Sprintf("%s/roles/%s", c.Path, c.Role)] = []string{"read"}
+	}
+
+	credentialType, err := c.awsCredentialType(ctx)
+	if err != nil && !errors.Is(err, ErrPermissionDenied) {
+		return nil, err
+	}
+	if err == nil {
+		method, endpoint := c.awsEndpoint(credentialType)
+		if method == http.MethodGet {
+			required[endpoint] = []string{"read"}
+		} else {
+			required[endpoint] = []string{"update"}
+		}
+	}
+	return c.CheckCapabilitiesContext(ctx, required)
+}
+
+// CanIssueAzure checks that the current token can request and revoke azure credentials for the config's role
+func (c Config) CanIssueAzure() (*CapabilityReport, error) {
+	return c.CanIssueAzureContext(context.Background())
+}
+
+// CanIssueAzureContext is like CanIssueAzure but cancels the request to vault when the context is done
+func (c Config) CanIssueAzureContext(ctx context.Context) (*CapabilityReport, error) {
+	return c.CheckCapabilitiesContext(ctx, map[string][]string{
+		fmt.Sprintf("%s/creds/%s", c.Path, c.Role): {"read"},
+		revokePath: {"update"},
+	})
+}
+
+// missingCapabilities returns the required capabilities that were not granted.
+// The root capability grants everything, and deny grants nothing.
+func missingCapabilities(required, granted []string) []string {
+	has := map[string]bool{}
+	for _, g := range granted {
+		has[g] = true
+	}
+	if has["root"] && !has["deny"] {
+		return nil
+	}
+
+	var missing []string
+	for _, r := range required {
+		if has["deny"] || !has[r] {
+			missing = append(missing, r)
+		}
+	}
+	return missing
+}
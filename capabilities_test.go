@@ -0,0 +1,163 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_CanIssue(t *testing.T) {
+	tests := []struct {
+		name        string
+		azure       bool
+		response    string
+		wantPaths   []string
+		wantAllowed bool
+		wantMissing []PathCapabilities
+	}{
+		{
+			name:        "aws allowed",
+			response:    `{"data":{"aws/sts/admin":["update"],"sys/leases/revoke":["create","update"]}}`,
+			wantPaths:   []string{"aws/sts/admin", "sys/leases/revoke"},
+			wantAllowed: true,
+		},
+		{
+			name:      "aws cannot revoke",
+			response:  `{"data":{"aws/sts/admin":["read","update"],"sys/leases/revoke":["deny"]}}`,
+			wantPaths: []string{"aws/sts/admin", "sys/leases/revoke"},
+			wantMissing: []PathCapabilities{
+				{Path: "sys/leases/revoke", Required: []string{"update"}, Granted: []string{"deny"}, Missing: []string{"update"}},
+			},
+		},
+		{
+			name:        "azure root",
+			azure:       true,
+			response:    `{"data":{"azure/creds/admin":["root"],"sys/leases/revoke":["root"]}}`,
+			wantPaths:   []string{"azure/creds/admin", "sys/leases/revoke"},
+			wantAllowed: true,
+		},
+		{
+			name:      "azure cannot read",
+			azure:     true,
+			response:  `{"data":{"azure/creds/admin":["list"],"sys/leases/revoke":["update"]}}`,
+			wantPaths: []string{"azure/creds/admin", "sys/leases/revoke"},
+			wantMissing: []PathCapabilities{
+				{Path: "azure/creds/admin", Required: []string{"read"}, Granted: []string{"list"}, Missing: []string{"read"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeTransport{responses: map[string]fakeResponse{
+				"POST sys/capabilities-self": {body: tt.response},
+			}}
+			c := Config{Role: "admin", Transport: fake}
+
+			var report *CapabilityReport
+			var err error
+			if tt.azure {
+				c.Path = "azure"
+				report, err = c.CanIssueAzure()
+			} else {
+				c.Path = "aws"
+				report, err = c.CanIssueAWS()
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"paths": tt.wantPaths}, fake.requests[0].Data)
+			assert.Equal(t, tt.wantAllowed, report.Allowed())
+			assert.Equal(t, tt.wantMissing, report.Missing())
+		})
+	}
+}
+
+func TestConfig_CanIssueAWS_credentialType(t *testing.T) {
+	tests := []struct {
+		name           string
+		credentialType string
+		responses      map[string]fakeResponse
+		wantPaths      []string
+		wantErr        bool
+	}{
+		{
+			name:           "iam user",
+			credentialType: AWSCredentialTypeIAMUser,
+			wantPaths:      []string{"aws/creds/admin", "sys/leases/revoke"},
+		},
+		{
+			name:           "auto detected session token",
+			credentialType: AWSCredentialTypeAuto,
+			responses: map[string]fakeResponse{
+				"GET aws/roles/admin": {body: `{"data":{"credential_type":"session_token"}}`},
+			},
+			wantPaths: []string{"aws/creds/admin", "aws/roles/admin", "sys/leases/revoke"},
+		},
+		{
+			name:           "role not readable",
+			credentialType: AWSCredentialTypeAuto,
+			responses: map[string]fakeResponse{
+				"GET aws/roles/admin": {err: &ResponseError{StatusCode: 403, Errors: []string{"permission denied"}}},
+			},
+			wantPaths: []string{"aws/roles/admin", "sys/leases/revoke"},
+		},
+		{
+			name:           "role lookup failed",
+			credentialType: AWSCredentialTypeAuto,
+			responses: map[string]fakeResponse{
+				"GET aws/roles/admin": {err: &ResponseError{StatusCode: 500, Errors: []string{"internal error"}}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responses := map[string]fakeResponse{
+				"POST sys/capabilities-self": {body: `{"data":{"aws/creds/admin":["read"],"aws/roles/admin":["deny"],"sys/leases/revoke":["update"]}}`},
+			}
+			for k, v := range tt.responses {
+				responses[k] = v
+			}
+			fake := &fakeTransport{responses: responses}
+			c := Config{Path: "aws", Role: "admin", AWSCredentialType: tt.credentialType, Transport: fake}
+
+			_, err := c.CanIssueAWS()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			last := fake.requests[len(fake.requests)-1]
+			assert.Equal(t, "sys/capabilities-self", last.Path)
+			assert.Equal(t, map[string]interface{}{"paths": tt.wantPaths}, last.Data)
+		})
+	}
+}
+
+func TestCapabilityReport_String(t *testing.T) {
+	report := &CapabilityReport{Paths: []PathCapabilities{
+		{Path: "aws/sts/admin", Missing: []string{"update"}},
+		{Path: "sys/leases/revoke"},
+	}}
+	assert.Equal(t, "missing vault capabilities: update on aws/sts/admin", report.String())
+}
+
+func TestConfig_CheckCapabilities_missingPath(t *testing.T) {
+	c := Config{Transport: &fakeTransport{responses: map[string]fakeResponse{
+		"POST sys/capabilities-self": {body: `{"data":{}}`},
+	}}}
+	_, err := c.CheckCapabilities(map[string][]string{"secret/data/foo": {"read"}})
+	assert.Error(t, err)
+}
@@ -15,7 +15,14 @@
 package vaultutil
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
 	"time"
+
+	"k8s.io/klog"
 )
 
 const (
@@ -23,6 +30,12 @@ const (
 	BaseURLGovCloud = "amazonaws-us-gov.com"
 	// BaseURLDefault is the normal AWS base URL
 	BaseURLDefault = "aws.amazon.com"
+	// DefaultMinTokenTTL is the number of seconds a token must remain valid for CheckToken
+	// to succeed when Config.MinTokenTTL is not set
+	DefaultMinTokenTTL = 30
+	// DefaultTimeout is the timeout of a single request when Config.Timeout is not set.
+	// It matches the default client timeout of the vault CLI.
+	DefaultTimeout = 60 * time.Second
 )
 
 // Config holds all the config
@@ -31,8 +44,46 @@ type Config struct {
 	Path       string
 	Role       string
 	TTL        string
+	// AWSCredentialType is the credential type of the vault aws role, e.g. AWSCredentialTypeIAMUser.
+	// It selects the endpoint used by AWSLogin. AWSCredentialTypeAuto reads it from the role, and
+	// the empty value uses the sts endpoint, which serves assumed_role and federation_token roles.
+	AWSCredentialType string
+	// RoleARN selects one of the role_arns of an assumed_role vault role. Vault requires it when
+	// the role has more than one.
+	RoleARN string
+	// RoleSessionName is the session name of assumed_role credentials, shown in CloudTrail
+	RoleSessionName string
+	// MFACode is the current code of the MFA device of a session_token vault role
+	MFACode string
+	// AWSProfile is a profile of the shared credentials file. When set, NewAWSCredentials reuses
+	// the credentials stored in it instead of the environment, and writes new ones to it.
+	AWSProfile string
+	// Username is used by the userpass and ldap logins
+	Username string
+	// Token is the vault token used for requests. Defaults to VAULT_TOKEN or the
+	// token stored in ~/.vault-token when empty.
+	Token string
+	// MinTokenTTL is the number of seconds the token must remain valid for CheckToken
+	// to succeed. Defaults to DefaultMinTokenTTL when zero.
+	MinTokenTTL int64
+	// Namespace is the Vault Enterprise namespace used for every request.
+	// Defaults to VAULT_NAMESPACE when empty.
+	Namespace string
+	// TLS holds the options used to verify and authenticate to the vault server
+	TLS TLSConfig
+	// Timeout limits each request to vault or AWS. Defaults to DefaultTimeout when zero,
+	// and a negative value disables it so only the caller's context applies.
+	Timeout time.Duration
+	// Retry controls how requests that fail with a transient error are retried.
+	// The zero value disables retries.
+	Retry RetryPolicy
 	// BufferSeconds is the number of seconds to renew before expiration
 	BufferSeconds int64
+	// UseCLI shells out to the vault binary instead of calling the Vault HTTP API
+	UseCLI bool
+	// Transport overrides how requests are sent to vault. When nil, an HTTPTransport
+	// configured from the environment is used, or a CLITransport if UseCLI is set.
+	Transport Transport
 }
 
 // NewConfig returns a config object
@@ -52,6 +103,139 @@ func NewConfig(partition, role, path string, buffer int64) *Config {
 	return ret
 }
 
+// transport returns the vault transport used by the config
+func (c Config) transport() (Transport, error) {
+	if c.Transport != nil {
+		return c.Transport, nil
+	}
+	tlsConfig := c.TLS.withEnv()
+	if c.UseCLI {
+		return CLITransport{TLS: tlsConfig}, nil
+	}
+
+	client, err := httpClient(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := NewHTTPTransport()
+	ret.Client = client
+	return ret, nil
+}
+
+var (
+	httpClientsMu sync.Mutex
+	// httpClients holds one client per TLS configuration
+	httpClients = map[TLSConfig]cachedHTTPClient{}
+)
+
+// cachedHTTPClient is an http client built from the certificate files at the given fileVersion
+type cachedHTTPClient struct {
+	client      *http.Client
+	fileVersion string
+}
+
+// httpClient returns the http client for the TLS options. Clients are built once and shared by every
+// config with the same options, so requests reuse pooled connections instead of reading the certificate
+// files and doing a new TLS handshake each time. A client is rebuilt when the certificate files change,
+// since short-lived client certificates and CA bundles are rotated while long-running users keep going.
+func httpClient(t TLSConfig) (*http.Client, error) {
+	version := t.fileVersion()
+
+	httpClientsMu.Lock()
+	defer httpClientsMu.Unlock()
+
+	cached, ok := httpClients[t]
+	if ok && cached.fileVersion == version {
+		return cached.client, nil
+	}
+
+	clientConfig, err := t.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	httpTransport := http.DefaultTransport.(*http.Transport).Clone()
+	httpTransport.TLSClientConfig = clientConfig
+
+	client := &http.Client{Transport: httpTransport}
+	if ok {
+		klog.V(3).Info("vault TLS files changed, reloading them")
+		cached.client.CloseIdleConnections()
+	}
+	httpClients[t] = cachedHTTPClient{client: client, fileVersion: version}
+	return client, nil
+}
+
+// request sends a request to vault using the config's transport. Failed attempts are only
+// retried when vault did not process the request, so it is safe for requests with side
+// effects such as creating an azure service principal.
+func (c Config) request(ctx context.Context, method, path string, data map[string]interface{}) ([]byte, error) {
+	return c.send(ctx, method, path, data, false)
+}
+
+// idempotentRequest is like request, but retries every transient failure. It is used for
+// reads, lookups and revocations, which can safely be repeated.
+func (c Config) idempotentRequest(ctx context.Context, method, path string, data map[string]interface{}) ([]byte, error) {
+	return c.send(ctx, method, path, data, true)
+}
+
+// send sends a request to vault, retrying it according to the config's retry policy
+func (c Config) send(ctx context.Context, method, path string, data map[string]interface{}, idempotent bool) ([]byte, error) {
+	transport, err := c.transport()
+	if err != nil {
+		return nil, err
+	}
+
+	req := &Request{
+		Method:    method,
+		Path:      path,
+		Data:      data,
+		Token:     c.Token,
+		Namespace: c.namespace(),
+	}
+	op := fmt.Sprintf("vault %s %s", method, path)
+
+	return c.Retry.do(ctx, op, idempotent, func(ctx context.Context) ([]byte, error) {
+		ctx, cancel := c.withTimeout(ctx)
+		defer cancel()
+
+		ret, err := transport.Do(ctx, req)
+		if err != nil {
+			return nil, timeoutError(ctx, op, err)
+		}
+		return ret, nil
+	})
+}
+
+// withTimeout returns a context limited by the config's timeout
+func (c Config) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	if timeout < 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// namespace returns the configured vault namespace, falling back to VAULT_NAMESPACE
+func (c Config) namespace() string {
+	if c.Namespace != "" {
+		return c.Namespace
+	}
+	return os.Getenv("VAULT_NAMESPACE")
+}
+
+// defaultRegion returns the region used when none is configured, which is us-east-1,
+// or us-gov-west-1 when the config uses GovCloud
+func (c Config) defaultRegion() string {
+	if c.AWSBaseURL == BaseURLGovCloud {
+		return "us-gov-west-1"
+	}
+	return "us-east-1"
+}
+
 // expired checks to see if a set of credentials are expired
 func expired(buffer, duration int64, created time.Time) bool {
 	elapsed := time.Since(created)
@@ -15,6 +15,20 @@
 package vaultutil
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
@@ -99,3 +113,115 @@ func TestNewConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_namespace(t *testing.T) {
+	defer os.Setenv("VAULT_NAMESPACE", os.Getenv("VAULT_NAMESPACE"))
+
+	os.Setenv("VAULT_NAMESPACE", "env/ns")
+	assert.Equal(t, "env/ns", Config{}.namespace())
+	assert.Equal(t, "team/aws", Config{Namespace: "team/aws"}.namespace())
+
+	fake := &fakeTransport{responses: map[string]fakeResponse{
+		"GET auth/token/lookup-self": {body: `{"data":{"ttl":3600}}`},
+	}}
+	c := Config{Namespace: "team/aws", Transport: fake}
+	assert.NoError(t, c.CheckToken())
+	assert.Equal(t, "team/aws", fake.requests[0].Namespace)
+}
+
+func TestConfig_namespace_reusedCredentials(t *testing.T) {
+	var namespaces []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespaces = append(namespaces, r.Header.Get("X-Vault-Namespace"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	start := strconv.FormatInt(time.Now().Unix(), 10)
+	defer setEnv(map[string]string{
+		"VAULT_ADDR":                 server.URL,
+		"VAULT_NAMESPACE":            "",
+		"AWS_ACCESS_KEY_ID":          "AKIA",
+		"AWS_SECRET_ACCESS_KEY":      "secret",
+		"AWS_SESSION_START":          start,
+		"AWS_SESSION_DURATION":       "3600",
+		"AWS_SESSION_VAULT_LEASE_ID": "aws/sts/admin/123",
+		"ARM_CLIENT_ID":              "id",
+		"ARM_CLIENT_SECRET":          "secret",
+		"ARM_SESSION_START":          start,
+		"ARM_SESSION_DURATION":       "3600",
+		"ARM_SESSION_VAULT_LEASE_ID": "azure/creds/admin/123",
+	})()
+	c := Config{Path: "aws", Role: "admin", BufferSeconds: 30, Namespace: "team/cloud"}
+
+	aws, err := c.NewAWSCredentials()
+	assert.NoError(t, err)
+	assert.NoError(t, aws.Revoke())
+
+	azure, err := c.NewAzureCredentials()
+	assert.NoError(t, err)
+	assert.NoError(t, azure.Revoke())
+
+	assert.Equal(t, []string{"team/cloud", "team/cloud"}, namespaces)
+}
+
+func TestConfig_transport_reusesClient(t *testing.T) {
+	first, err := Config{}.transport()
+	assert.NoError(t, err)
+	second, err := Config{Namespace: "team/aws"}.transport()
+	assert.NoError(t, err)
+	insecure, err := Config{TLS: TLSConfig{Insecure: true}}.transport()
+	assert.NoError(t, err)
+
+	assert.Same(t, first.(*HTTPTransport).Client, second.(*HTTPTransport).Client)
+	assert.NotSame(t, first.(*HTTPTransport).Client, insecure.(*HTTPTransport).Client)
+}
+
+func TestConfig_transport_reloadsCertificates(t *testing.T) {
+	var presented []string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented = append(presented, r.TLS.PeerCertificates[0].Subject.CommonName)
+		_, _ = w.Write([]byte(`{"data":{"ttl":3600}}`))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	assert.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	defer setEnv(map[string]string{"VAULT_ADDR": server.URL})()
+
+	c := Config{TLS: TLSConfig{CACert: caFile, ClientCert: certFile, ClientKey: keyFile}}
+	writeClientCert(t, certFile, keyFile, "first")
+	assert.NoError(t, c.CheckToken())
+	assert.NoError(t, c.CheckToken())
+
+	// a rotated client certificate is used by the next request
+	writeClientCert(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, later, later))
+	assert.NoError(t, c.CheckToken())
+	assert.Equal(t, []string{"first", "first", "second"}, presented)
+}
+
+// writeClientCert writes a self-signed client certificate and its key
+func writeClientCert(t *testing.T, certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// CredentialProcessOutput is the JSON printed by a credential_process of the AWS CLI and SDKs.
+// See https://docs.aws.amazon.com/cli/latest/userguide/cli-configure-sourcing-external.html
+type CredentialProcessOutput struct {
+	// Version is always 1
+	Version int `json:"Version"`
+	// AccessKeyID is the access key ID of the credentials
+	AccessKeyID string `json:"AccessKeyId"`
+	// SecretAccessKey is the secret access key of the credentials
+	SecretAccessKey string `json:"SecretAccessKey"`
+	// SessionToken is omitted for iam_user credentials
+	SessionToken string `json:"SessionToken,omitempty"`
+	// Expiration is the RFC3339 time the vault lease expires. It is omitted if the duration is unknown.
+	Expiration string `json:"Expiration,omitempty"`
+}
+
+// CredentialProcess returns the credentials as credential_process JSON
+func (a *AWSCredentials) CredentialProcess() ([]byte, error) {
+	out := CredentialProcessOutput{
+		Version:         1,
+		AccessKeyID:     a.AccessKeyID,
+		SecretAccessKey: a.SecretAccessKey,
+		SessionToken:    a.SessionToken,
+	}
+	if a.Duration > 0 {
+		out.Expiration = a.Created.Add(time.Duration(a.Duration) * time.Second).UTC().Format(time.RFC3339)
+	}
+	return json.Marshal(out)
+}
+
+// CredentialProcess returns credential_process JSON for use in an AWS config profile, e.g.
+//
+//	[profile vault]
+//	credential_process = my-tool credential-process --role admin
+//
+// Credentials are cached in cacheFile, which defaults to a file per role in the user cache directory
+// when empty. The cached credentials are reused until they expire with Config.BufferSeconds, so
+// repeated invocations do not issue new credentials every time. Unlike NewAWSCredentials, credentials in
+// the environment are ignored.
+func (c Config) CredentialProcess(cacheFile string) ([]byte, error) {
+	return c.CredentialProcessContext(context.Background(), cacheFile)
+}
+
+// CredentialProcessContext is like CredentialProcess but cancels the request to vault when the context is done
+func (c Config) CredentialProcessContext(ctx context.Context, cacheFile string) ([]byte, error) {
+	if cacheFile == "" {
+		var err error
+		cacheFile, err = c.credentialCacheFile()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0700); err != nil {
+		return nil, fmt.Errorf("error creating credential cache directory: %w", err)
+	}
+
+	// concurrent invocations wait for the first one instead of issuing their own credentials. The
+	// wait is only limited by the context, since the holder may retry slow vault requests for longer
+	// than profileLockTimeout.
+	unlock, err := lockFile(ctx, cacheFile+".lock")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	creds, err := readCredentialCache(cacheFile)
+	if err == nil && !creds.Expired(c.BufferSeconds) {
+		klog.V(3).Infof("using cached credentials from %s", cacheFile)
+		return creds.CredentialProcess()
+	}
+	if err != nil {
+		klog.V(3).Infof("unable to read credential cache: %s", err.Error())
+	}
+
+	// credentials are never read from the environment here, since AWS_* variables
+	// may belong to another role or account than the one of the profile
+	if err != nil || !c.renewAWSCredentials(ctx, creds) {
+		creds, err = c.AWSLoginContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(creds)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(cacheFile, data); err != nil {
+		return nil, fmt.Errorf("error writing credential cache: %w", err)
+	}
+	return creds.CredentialProcess()
+}
+
+// credentialCacheFile returns the default cache file of the config. The name is a hash of the
+// vault server and the settings that select the credentials, so every role gets its own file.
+func (c Config) credentialCacheFile() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to find cache directory: %w", err)
+	}
+	address := vaultAddr()
+	if t, ok := c.Transport.(*HTTPTransport); ok {
+		address = t.Address
+	}
+	key := strings.Join([]string{address, c.namespace(), c.Path, c.Role, c.AWSCredentialType, c.RoleARN, c.RoleSessionName, c.TTL}, "\n")
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dir, "vaultutil", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// readCredentialCache reads credentials written by CredentialProcess
+func readCredentialCache(file string) (*AWSCredentials, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	creds := &AWSCredentials{}
+	if err := json.Unmarshal(data, creds); err != nil {
+		return nil, fmt.Errorf("error unmarshaling credential cache: %s", err.Error())
+	}
+	if err := creds.buildEnv(); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
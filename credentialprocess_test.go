@@ -0,0 +1,183 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAWSCredentials_CredentialProcess(t *testing.T) {
+	tests := []struct {
+		name  string
+		creds *AWSCredentials
+		want  string
+	}{
+		{
+			name: "sts",
+			creds: &AWSCredentials{
+				AccessKeyID:     "ASIA",
+				SecretAccessKey: "secret",
+				SessionToken:    "token",
+				Created:         time.Unix(1600000000, 0),
+				Duration:        3600,
+			},
+			want: `{"Version":1,"AccessKeyId":"ASIA","SecretAccessKey":"secret","SessionToken":"token","Expiration":"2020-09-13T13:26:40Z"}`,
+		},
+		{
+			name: "iam user without duration",
+			creds: &AWSCredentials{
+				AccessKeyID:     "AKIA",
+				SecretAccessKey: "secret",
+			},
+			want: `{"Version":1,"AccessKeyId":"AKIA","SecretAccessKey":"secret"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.creds.CredentialProcess()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestConfig_CredentialProcess(t *testing.T) {
+	// valid credentials of another role in the environment must not be used
+	defer setEnv(map[string]string{
+		"AWS_ACCESS_KEY_ID":          "ENV",
+		"AWS_SECRET_ACCESS_KEY":      "secret",
+		"AWS_SESSION_TOKEN":          "token",
+		"AWS_SESSION_START":          strconv.FormatInt(time.Now().Unix(), 10),
+		"AWS_SESSION_DURATION":       "3600",
+		"AWS_SESSION_VAULT_LEASE_ID": "aws/sts/other/123",
+	})()
+	cacheFile := filepath.Join(t.TempDir(), "cache", "admin.json")
+
+	c, fake := newSTSConfig(fakeResponse{body: stsResponse})
+
+	first, err := c.CredentialProcess(cacheFile)
+	assert.NoError(t, err)
+	second, err := c.CredentialProcess(cacheFile)
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.Len(t, fake.requests, 1)
+
+	out := CredentialProcessOutput{}
+	assert.NoError(t, json.Unmarshal(first, &out))
+	assert.Equal(t, 1, out.Version)
+	assert.Equal(t, "ASIA", out.AccessKeyID)
+	expiration, err := time.Parse(time.RFC3339, out.Expiration)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiration, time.Minute)
+
+	// credentials within the buffer of their expiration are replaced
+	expired := &AWSCredentials{
+		AccessKeyID:     "OLD",
+		SecretAccessKey: "secret",
+		Created:         time.Now().Add(-time.Hour),
+		Duration:        3610,
+		LeaseID:         "aws/sts/admin/old",
+	}
+	data, err := json.Marshal(expired)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(cacheFile, data, 0600))
+
+	third, err := c.CredentialProcess(cacheFile)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(third), "OLD")
+	assert.Len(t, fake.requests, 2)
+}
+
+func TestConfig_CredentialProcess_concurrent(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "admin.json")
+
+	var issued int32
+	c := NewConfig("aws", "admin", "aws", 30)
+	c.Transport = transportFunc(func(ctx context.Context, req *Request) ([]byte, error) {
+		atomic.AddInt32(&issued, 1)
+		// a slow vault keeps the lock held while the other invocations wait
+		time.Sleep(200 * time.Millisecond)
+		return []byte(stsResponse), nil
+	})
+
+	var wg sync.WaitGroup
+	outputs := make([][]byte, 5)
+	for i := range outputs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			out, err := c.CredentialProcess(cacheFile)
+			assert.NoError(t, err)
+			outputs[i] = out
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&issued))
+	for _, out := range outputs {
+		assert.Equal(t, outputs[0], out)
+	}
+	_, err := os.Stat(cacheFile + ".lock")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestConfig_credentialCacheFile(t *testing.T) {
+	defer setEnv(map[string]string{"VAULT_ADDR": "https://vault.example.com"})()
+	admin, err := Config{Path: "aws", Role: "admin"}.credentialCacheFile()
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{
+			name:   "role",
+			config: Config{Path: "aws", Role: "readonly"},
+		},
+		{
+			name:   "role session name",
+			config: Config{Path: "aws", Role: "admin", RoleSessionName: "ci"},
+		},
+		{
+			name:   "transport address",
+			config: Config{Path: "aws", Role: "admin", Transport: &HTTPTransport{Address: "https://other.example.com"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.credentialCacheFile()
+			assert.NoError(t, err)
+			assert.NotEqual(t, admin, got)
+			assert.Equal(t, filepath.Dir(admin), filepath.Dir(got))
+		})
+	}
+
+	// the vault address comes from VAULT_ADDR without an http transport
+	defer setEnv(map[string]string{"VAULT_ADDR": "https://other.example.com"})()
+	other, err := Config{Path: "aws", Role: "admin"}.credentialCacheFile()
+	assert.NoError(t, err)
+	assert.NotEqual(t, admin, other)
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// awsCredentialSource issues aws credentials from vault and keeps them until they expire
+// with the buffer of the config. It is shared by the credential providers and servers, and
+// is safe for concurrent use.
+type awsCredentialSource struct {
+	config Config
+
+	mu    sync.Mutex
+	creds *AWSCredentials
+}
+
+// newAWSCredentialSource returns a credential source for the config
+func newAWSCredentialSource(c Config) *awsCredentialSource {
+	return &awsCredentialSource{config: c}
+}
+
+// get returns a copy of the current credentials, renewing or replacing them when they are expired.
+// A copy is returned because renewal updates the credentials in place.
+func (s *awsCredentialSource) get(ctx context.Context) (*AWSCredentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.creds == nil || s.creds.Expired(s.config.BufferSeconds) {
+		if s.creds == nil || !s.config.renewAWSCredentials(ctx, s.creds) {
+			klog.V(3).Info("getting new aws credentials from vault")
+			creds, err := s.config.AWSLoginContext(ctx)
+			if err != nil {
+				return nil, err
+			}
+			s.creds = creds
+		}
+	}
+	ret := *s.creds
+	return &ret, nil
+}
+
+// refresh keeps the credentials valid in the background until the context is done, so requests
+// never wait for vault. Failures are logged and retried after retryInterval.
+func (s *awsCredentialSource) refresh(ctx context.Context, retryInterval time.Duration) {
+	for {
+		wait := retryInterval
+		if _, err := s.get(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			klog.Errorf("unable to refresh aws credentials: %s", err.Error())
+		} else if expiresIn := time.Until(s.expiresAt()); expiresIn > 0 {
+			wait = expiresIn
+		} else {
+			// the lease is shorter than the buffer, so avoid issuing credentials in a tight loop
+			klog.Warningf("aws credentials expire within the buffer of %d seconds", s.config.BufferSeconds)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// expired returns true if there are no credentials yet, or if they expire within the buffer
+func (s *awsCredentialSource) expired() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.creds == nil || s.creds.Expired(s.config.BufferSeconds)
+}
+
+// expiresAt returns the time the current credentials should be replaced, which is
+// the buffer before their lease expires
+func (s *awsCredentialSource) expiresAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.creds == nil {
+		return time.Time{}
+	}
+	return s.creds.expiresAt(s.config.BufferSeconds)
+}
+
+// expiresAt returns the time the credentials expire with the buffer
+func (a *AWSCredentials) expiresAt(buffer int64) time.Time {
+	return a.Created.Add(time.Duration(a.Duration-buffer) * time.Second)
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAWSCredentialSource_renew(t *testing.T) {
+	fake := &fakeTransport{responses: map[string]fakeResponse{
+		"GET aws/creds/admin":  {body: `{"lease_id":"aws/creds/admin/123","lease_duration":3600,"renewable":true,"data":{"access_key":"AKIA","secret_key":"secret"}}`},
+		"PUT sys/leases/renew": {body: `{"lease_id":"aws/creds/admin/123","lease_duration":3600,"renewable":true}`},
+	}}
+	c := NewConfig("aws", "admin", "aws", 30)
+	c.AWSCredentialType = AWSCredentialTypeIAMUser
+	c.Transport = fake
+	source := newAWSCredentialSource(*c)
+
+	_, err := source.get(context.Background())
+	assert.NoError(t, err)
+	source.creds.Created = time.Now().Add(-time.Hour)
+
+	second, err := source.get(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIA", second.AccessKeyID)
+	assert.False(t, source.expired())
+	assert.Len(t, fake.requests, 2)
+	assert.Equal(t, "sys/leases/renew", fake.requests[1].Path)
+}
+
+func TestAWSCredentialSource_refresh(t *testing.T) {
+	var issued int32
+	c := NewConfig("aws", "admin", "aws", 30)
+	c.Transport = transportFunc(func(ctx context.Context, req *Request) ([]byte, error) {
+		n := atomic.AddInt32(&issued, 1)
+		// the credentials expire one second after the buffer
+		return []byte(fmt.Sprintf(`{"lease_id":"aws/sts/admin/%d","lease_duration":31,"data":{"access_key":"ASIA","secret_key":"secret","security_token":"token"}}`, n)), nil
+	})
+	source := newAWSCredentialSource(*c)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		source.refresh(ctx, time.Second)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&issued) >= 2 }, 5*time.Second, 50*time.Millisecond)
+	cancel()
+	<-done
+}
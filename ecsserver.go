@@ -0,0 +1,232 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultECSAddress is the listen address of the ECS credentials server. The port is chosen by the OS.
+	DefaultECSAddress = "127.0.0.1:0"
+	// ECSCredentialsPath is the path the ECS credentials server serves credentials on
+	ECSCredentialsPath = "/credentials"
+)
+
+// ECSServerOptions are the options of StartECSServer
+type ECSServerOptions struct {
+	// Address is the loopback address to listen on. Defaults to DefaultECSAddress.
+	Address string
+	// AuthToken is the value clients must send in the Authorization header.
+	// A random token is generated when empty.
+	AuthToken string
+	// RetryInterval is how long to wait before retrying a failed refresh.
+	// Defaults to DefaultRenewRetryInterval.
+	RetryInterval time.Duration
+}
+
+// ECSServer serves vault issued aws credentials in the format of the ECS container credentials
+// endpoint. Point AWS SDKs and the AWS CLI at it with the variables returned by Env.
+type ECSServer struct {
+	// URL is the credentials endpoint, for AWS_CONTAINER_CREDENTIALS_FULL_URI
+	URL string
+	// AuthToken is the token clients must send, for AWS_CONTAINER_AUTHORIZATION_TOKEN
+	AuthToken string
+
+	server *credentialServer
+}
+
+// ecsCredentials is the response of the ECS container credentials endpoint
+type ecsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token,omitempty"`
+	Expiration      string `json:"Expiration"`
+}
+
+// StartECSServer starts a loopback ECS container credentials server. The first credentials are
+// issued before it returns, and they are refreshed in the background Config.BufferSeconds before
+// they expire, so clients never receive stale credentials. The server stops when the context is
+// done or Close is called.
+func (c Config) StartECSServer(ctx context.Context, opts ECSServerOptions) (*ECSServer, error) {
+	address := opts.Address
+	if address == "" {
+		address = DefaultECSAddress
+	}
+	if err := checkLocalAddress(address, false); err != nil {
+		return nil, err
+	}
+
+	token := opts.AuthToken
+	if token == "" {
+		var err error
+		token, err = randomHex(32)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	source := newAWSCredentialSource(c)
+	server, err := startCredentialServer(ctx, address, source, opts.RetryInterval, ecsCredentialsHandler(source, token))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &ECSServer{
+		URL:       fmt.Sprintf("http://%s%s", server.addr, ECSCredentialsPath),
+		AuthToken: token,
+		server:    server,
+	}
+	klog.V(2).Infof("serving ecs container credentials at %s", ret.URL)
+	return ret, nil
+}
+
+// Env returns the environment variables that point AWS SDKs and the AWS CLI at the server
+func (s *ECSServer) Env() map[string]string {
+	return map[string]string{
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI": s.URL,
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN":  s.AuthToken,
+	}
+}
+
+// Close stops the server and the background refresh
+func (s *ECSServer) Close() error {
+	s.server.close()
+	return nil
+}
+
+// ecsCredentialsHandler serves the credentials of the source to clients sending the token
+func ecsCredentialsHandler(source *awsCredentialSource, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(token)) != 1 {
+			http.Error(w, "invalid authorization token", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != ECSCredentialsPath {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		creds, err := source.get(r.Context())
+		if err != nil {
+			klog.Errorf("unable to serve aws credentials: %s", err.Error())
+			http.Error(w, "unable to get credentials from vault", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, ecsCredentials{
+			AccessKeyID:     creds.AccessKeyID,
+			SecretAccessKey: creds.SecretAccessKey,
+			Token:           creds.SessionToken,
+			Expiration:      creds.expiresAt(source.config.BufferSeconds).UTC().Format(time.RFC3339),
+		})
+	})
+}
+
+// credentialServer is an http server that hands out the credentials of a source, which it refreshes
+// in the background
+type credentialServer struct {
+	addr   string
+	server *http.Server
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// startCredentialServer issues the first credentials, then starts serving the handler and refreshing the source
+func startCredentialServer(ctx context.Context, address string, source *awsCredentialSource, retryInterval time.Duration, handler http.Handler) (*credentialServer, error) {
+	if retryInterval <= 0 {
+		retryInterval = DefaultRenewRetryInterval
+	}
+	if _, err := source.get(ctx); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", address, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &credentialServer{
+		addr:   listener.Addr().String(),
+		server: &http.Server{Handler: handler},
+		cancel: cancel,
+	}
+
+	s.wg.Add(3)
+	go func() {
+		defer s.wg.Done()
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("credential server failed: %s", err.Error())
+		}
+	}()
+	go func() {
+		defer s.wg.Done()
+		source.refresh(ctx, retryInterval)
+	}()
+	go func() {
+		defer s.wg.Done()
+		<-ctx.Done()
+		_ = s.server.Close()
+	}()
+	return s, nil
+}
+
+// close stops the server and waits for its goroutines to finish
+func (s *credentialServer) close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// checkLocalAddress returns an error unless the listen address is a loopback address, or a
+// link-local address such as the one of the EC2 metadata service when linkLocal is set.
+// Credentials must never be served to other hosts.
+func checkLocalAddress(address string, linkLocal bool) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %s: %w", address, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && (ip.IsLoopback() || (linkLocal && ip.IsLinkLocalUnicast())) {
+		return nil
+	}
+	if linkLocal {
+		return fmt.Errorf("listen address %s is not a loopback or link-local address", address)
+	}
+	return fmt.Errorf("listen address %s is not a loopback address", address)
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		klog.Errorf("error writing response: %s", err.Error())
+	}
+}
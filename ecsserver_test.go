@@ -0,0 +1,96 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials/endpointcreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_StartECSServer(t *testing.T) {
+	c, _ := newSTSConfig(fakeResponse{body: stsResponse})
+
+	server, err := c.StartECSServer(context.Background(), ECSServerOptions{})
+	assert.NoError(t, err)
+	defer server.Close()
+	assert.True(t, strings.HasPrefix(server.URL, "http://127.0.0.1:"))
+	assert.Len(t, server.AuthToken, 64)
+	assert.Equal(t, server.URL, server.Env()["AWS_CONTAINER_CREDENTIALS_FULL_URI"])
+
+	// the aws sdk reads the same format from AWS_CONTAINER_CREDENTIALS_FULL_URI
+	creds := endpointcreds.NewCredentialsClient(*defaults.Config(), defaults.Handlers(), server.URL, func(p *endpointcreds.Provider) {
+		p.AuthorizationToken = server.AuthToken
+	})
+	value, err := creds.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "ASIA", value.AccessKeyID)
+	assert.Equal(t, "secret", value.SecretAccessKey)
+	assert.Equal(t, "token", value.SessionToken)
+	assert.False(t, creds.IsExpired())
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{name: "missing token", path: ECSCredentialsPath, wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", path: ECSCredentialsPath, token: "wrong", wantStatus: http.StatusUnauthorized},
+		{name: "wrong path", path: "/other", token: server.AuthToken, wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, strings.Replace(server.URL, ECSCredentialsPath, tt.path, 1), nil)
+			assert.NoError(t, err)
+			req.Header.Set("Authorization", tt.token)
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestConfig_StartECSServer_errors(t *testing.T) {
+	c, _ := newSTSConfig(fakeResponse{err: fmt.Errorf("permission denied")})
+
+	_, err := c.StartECSServer(context.Background(), ECSServerOptions{Address: "0.0.0.0:0"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not a loopback address")
+
+	_, err = c.StartECSServer(context.Background(), ECSServerOptions{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "permission denied")
+}
+
+func TestConfig_StartECSServer_cancel(t *testing.T) {
+	c, _ := newSTSConfig(fakeResponse{body: stsResponse})
+	ctx, cancel := context.WithCancel(context.Background())
+	server, err := c.StartECSServer(ctx, ECSServerOptions{Address: "localhost:0", AuthToken: "secret-token"})
+	assert.NoError(t, err)
+	assert.Equal(t, "secret-token", server.AuthToken)
+
+	cancel()
+	assert.NoError(t, server.Close())
+	_, err = http.Get(server.URL)
+	assert.Error(t, err)
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrTimeout matches any TimeoutError when used with errors.Is
+	ErrTimeout = errors.New("request timed out")
+	// ErrPermissionDenied matches a ResponseError with status 403
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrInvalidToken matches a ResponseError caused by an expired, revoked or unknown token
+	ErrInvalidToken = errors.New("invalid vault token")
+	// ErrSealed matches a ResponseError returned by a sealed vault
+	ErrSealed = errors.New("vault is sealed")
+	// ErrNotFound matches a ResponseError with status 404
+	ErrNotFound = errors.New("not found")
+	// ErrRoleNotFound matches a ResponseError caused by a role that does not exist in the secrets engine
+	ErrRoleNotFound = errors.New("vault role not found")
+	// ErrRateLimited matches a ResponseError with status 429
+	ErrRateLimited = errors.New("rate limited by vault")
+	// ErrConnection matches any ConnectionError
+	ErrConnection = errors.New("unable to connect to vault")
+	// ErrTLSHandshake matches a ConnectionError caused by a failed TLS handshake
+	ErrTLSHandshake = errors.New("tls handshake with vault failed")
+	// ErrTokenExpiring is returned by CheckToken when the token is about to expire
+	ErrTokenExpiring = errors.New("vault token is about to expire")
+)
+
+// ResponseError is returned when vault answers a request with an error status.
+// Use errors.Is with the Err sentinels to check for common failures, or errors.As
+// to inspect the response.
+type ResponseError struct {
+	// Method is the HTTP method of the request
+	Method string
+	// Path is the vault path of the request, e.g. aws/sts/admin
+	Path string
+	// StatusCode is the HTTP status returned by vault. It is zero if it could not be determined.
+	StatusCode int
+	// Errors are the error messages returned by vault
+	Errors []string `json:"errors"`
+}
+
+// Error implements the error interface
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%s %s returned status %d: %s", e.Method, e.Path, e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+// Is allows errors.Is to match the sentinel errors describing the response
+func (e *ResponseError) Is(target error) bool {
+	switch target {
+	case ErrPermissionDenied:
+		return e.StatusCode == http.StatusForbidden
+	case ErrInvalidToken:
+		return e.StatusCode == http.StatusForbidden && e.contains("invalid token", "bad token", "token expired")
+	case ErrSealed:
+		return e.StatusCode == http.StatusServiceUnavailable && e.contains("sealed")
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRoleNotFound:
+		return (e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusNotFound) &&
+			e.contains("role") && e.contains("not found", "does not exist", "unknown role")
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// contains returns true if any error message contains any of the substrings, ignoring case
+func (e *ResponseError) contains(substrs ...string) bool {
+	for _, msg := range e.Errors {
+		msg = strings.ToLower(msg)
+		for _, s := range substrs {
+			if strings.Contains(msg, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ConnectionError is returned when a request could not reach vault
+type ConnectionError struct {
+	// Address is the vault address of the request
+	Address string
+	// TLS is true if the connection failed during the TLS handshake
+	TLS bool
+	// Err is the underlying network error
+	Err error
+}
+
+// Error implements the error interface
+func (e *ConnectionError) Error() string {
+	if e.TLS {
+		return fmt.Sprintf("tls handshake with vault at %s failed: %s", e.Address, e.Err.Error())
+	}
+	return fmt.Sprintf("unable to connect to vault at %s: %s", e.Address, e.Err.Error())
+}
+
+// Unwrap returns the underlying network error
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// Is allows errors.Is to match ErrConnection and ErrTLSHandshake
+func (e *ConnectionError) Is(target error) bool {
+	return target == ErrConnection || (e.TLS && target == ErrTLSHandshake)
+}
+
+// TimeoutError is returned when a request is stopped by a context deadline,
+// either the caller's or the one set by Config.Timeout.
+// It matches both ErrTimeout and context.DeadlineExceeded with errors.Is.
+type TimeoutError struct {
+	// Op describes the request that timed out
+	Op string
+	// Err is the error returned by the request
+	Err error
+}
+
+// Error implements the error interface
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out: %s", e.Op, e.Err.Error())
+}
+
+// Unwrap returns the error returned by the request
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Is allows errors.Is to match ErrTimeout and context.DeadlineExceeded
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout || target == context.DeadlineExceeded
+}
+
+// timeoutError wraps err in a TimeoutError if the context deadline was exceeded
+func timeoutError(ctx context.Context, op string, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &TimeoutError{Op: op, Err: err}
+	}
+	return err
+}
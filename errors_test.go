@@ -0,0 +1,162 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingTransport never answers and returns the context error once it is done
+type blockingTransport struct{}
+
+func (blockingTransport) Do(ctx context.Context, req *Request) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestTimeoutError(t *testing.T) {
+	c := Config{Transport: blockingTransport{}, Timeout: 10 * time.Millisecond}
+
+	err := c.CheckToken()
+	assert.True(t, errors.Is(err, ErrTimeout))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	var timeout *TimeoutError
+	assert.True(t, errors.As(err, &timeout))
+	assert.Equal(t, "vault GET auth/token/lookup-self", timeout.Op)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.Timeout = -1
+	_, err = c.AWSLoginContext(ctx)
+	assert.True(t, errors.Is(err, ErrTimeout))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = c.AzureLoginContext(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.False(t, errors.Is(err, ErrTimeout))
+}
+
+func TestResponseError_Is(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    *ResponseError
+		target error
+		want   bool
+	}{
+		{
+			name:   "permission denied",
+			err:    &ResponseError{StatusCode: 403, Errors: []string{"permission denied"}},
+			target: ErrPermissionDenied,
+			want:   true,
+		},
+		{
+			name:   "permission denied is not invalid token",
+			err:    &ResponseError{StatusCode: 403, Errors: []string{"permission denied"}},
+			target: ErrInvalidToken,
+		},
+		{
+			name:   "invalid token",
+			err:    &ResponseError{StatusCode: 403, Errors: []string{"permission denied", "invalid token"}},
+			target: ErrInvalidToken,
+			want:   true,
+		},
+		{
+			name:   "sealed",
+			err:    &ResponseError{StatusCode: 503, Errors: []string{"Vault is sealed"}},
+			target: ErrSealed,
+			want:   true,
+		},
+		{
+			name:   "aws role not found",
+			err:    &ResponseError{StatusCode: 400, Errors: []string{"Role \"admin\" not found"}},
+			target: ErrRoleNotFound,
+			want:   true,
+		},
+		{
+			name:   "azure role not found",
+			err:    &ResponseError{StatusCode: 400, Errors: []string{"role 'admin' does not exist"}},
+			target: ErrRoleNotFound,
+			want:   true,
+		},
+		{
+			name:   "rate limited",
+			err:    &ResponseError{StatusCode: 429},
+			target: ErrRateLimited,
+			want:   true,
+		},
+		{
+			name:   "not found",
+			err:    &ResponseError{StatusCode: 404},
+			target: ErrNotFound,
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapped := fmt.Errorf("vault aws credentials failed: %w", tt.err)
+			assert.Equal(t, tt.want, errors.Is(wrapped, tt.target))
+		})
+	}
+}
+
+func TestConfig_responseErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+	}))
+	defer os.Setenv("VAULT_ADDR", os.Getenv("VAULT_ADDR"))
+	os.Setenv("VAULT_ADDR", server.URL)
+
+	_, err := NewConfig("aws", "admin", "aws", 30).AWSLogin()
+	assert.True(t, errors.Is(err, ErrPermissionDenied))
+	var respErr *ResponseError
+	assert.True(t, errors.As(err, &respErr))
+	assert.Equal(t, 403, respErr.StatusCode)
+	assert.Equal(t, "aws/sts/admin", respErr.Path)
+	assert.Equal(t, []string{"permission denied"}, respErr.Errors)
+
+	server.Close()
+	_, err = NewConfig("aws", "admin", "aws", 30).AWSLogin()
+	assert.True(t, errors.Is(err, ErrConnection))
+	assert.False(t, errors.Is(err, ErrTLSHandshake))
+}
+
+func TestParseCLIError(t *testing.T) {
+	output := `Error writing data to aws/sts/admin: Error making API request.
+
+URL: PUT https://127.0.0.1:8200/v1/aws/sts/admin
+Code: 403. Errors:
+
+* 1 error occurred:
+	* permission denied`
+	err := parseCLIError(&Request{Method: "PUT", Path: "aws/sts/admin"}, output, fmt.Errorf("exit code 2"))
+	assert.True(t, errors.Is(err, ErrPermissionDenied))
+	var respErr *ResponseError
+	assert.True(t, errors.As(err, &respErr))
+	assert.Equal(t, []string{"permission denied"}, respErr.Errors)
+
+	err = parseCLIError(&Request{}, "command not found", fmt.Errorf("exit code -1"))
+	assert.EqualError(t, err, "exit code -1")
+}
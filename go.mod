@@ -1,9 +1,20 @@
 module github.com/fairwindsops/vaultutil
 
-go 1.15
+go 1.17
 
 require (
 	github.com/aws/aws-sdk-go v1.44.168
+	github.com/aws/aws-sdk-go-v2 v1.17.3
 	github.com/stretchr/testify v1.8.1
+	golang.org/x/term v0.1.0
 	k8s.io/klog v1.0.0
 )
+
+require (
+	github.com/aws/smithy-go v1.13.5 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.1.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
@@ -0,0 +1,277 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultIMDSAddress is the listen address of the IMDS server. The port is chosen by the OS.
+	// Binding the address of the real metadata service, 169.254.169.254:80, usually requires root.
+	DefaultIMDSAddress = "127.0.0.1:0"
+	// DefaultIMDSRoleName is the name of the instance profile role reported by the IMDS server
+	DefaultIMDSRoleName = "vault"
+	// maxIMDSTokenTTL is the longest session token lifetime accepted by IMDSv2, in seconds
+	maxIMDSTokenTTL = 21600
+)
+
+// IMDSServerOptions are the options of StartIMDSServer
+type IMDSServerOptions struct {
+	// Address is the loopback or link-local address to listen on. Defaults to DefaultIMDSAddress.
+	Address string
+	// RoleName is the role listed under iam/security-credentials. Defaults to DefaultIMDSRoleName.
+	RoleName string
+	// Region is reported in the identity document. Defaults to us-east-1, or us-gov-west-1
+	// when the config uses GovCloud.
+	Region string
+	// AccountID is reported in the identity document. Defaults to the account of Config.RoleARN, if any.
+	AccountID string
+	// InstanceID is reported in the identity document. Defaults to i-00000000000000000.
+	InstanceID string
+	// RetryInterval is how long to wait before retrying a failed refresh.
+	// Defaults to DefaultRenewRetryInterval.
+	RetryInterval time.Duration
+}
+
+// IMDSServer emulates the credential endpoints of the EC2 instance metadata service (IMDSv2)
+// with vault issued aws credentials. Only requests with a session token from PUT /latest/api/token
+// are answered.
+type IMDSServer struct {
+	// URL is the endpoint of the server, for AWS_EC2_METADATA_SERVICE_ENDPOINT
+	URL string
+
+	server *credentialServer
+}
+
+// imdsCredentials is the response of the iam/security-credentials/<role> endpoint
+type imdsCredentials struct {
+	Code            string `json:"Code"`
+	LastUpdated     string `json:"LastUpdated"`
+	Type            string `json:"Type"`
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+}
+
+// imdsIdentityDocument is the response of the dynamic/instance-identity/document endpoint
+type imdsIdentityDocument struct {
+	AccountID        string `json:"accountId"`
+	Architecture     string `json:"architecture"`
+	AvailabilityZone string `json:"availabilityZone"`
+	ImageID          string `json:"imageId"`
+	InstanceID       string `json:"instanceId"`
+	InstanceType     string `json:"instanceType"`
+	PendingTime      string `json:"pendingTime"`
+	PrivateIP        string `json:"privateIp"`
+	Region           string `json:"region"`
+	Version          string `json:"version"`
+}
+
+var accountIDRegexp = regexp.MustCompile(`^arn:aws[a-z-]*:iam::(\d{12}):`)
+
+// StartIMDSServer starts a server that emulates the IMDSv2 credential endpoints, for tools that can only
+// read credentials from the EC2 instance metadata service. Like StartECSServer, the first credentials are
+// issued before it returns and they are refreshed in the background Config.BufferSeconds before they expire.
+// The server stops when the context is done or Close is called.
+func (c Config) StartIMDSServer(ctx context.Context, opts IMDSServerOptions) (*IMDSServer, error) {
+	address := opts.Address
+	if address == "" {
+		address = DefaultIMDSAddress
+	}
+	if err := checkLocalAddress(address, true); err != nil {
+		return nil, err
+	}
+	if opts.RoleName == "" {
+		opts.RoleName = DefaultIMDSRoleName
+	}
+	if opts.Region == "" {
+		opts.Region = c.defaultRegion()
+	}
+	if opts.AccountID == "" {
+		if match := accountIDRegexp.FindStringSubmatch(c.RoleARN); match != nil {
+			opts.AccountID = match[1]
+		}
+	}
+	if opts.InstanceID == "" {
+		opts.InstanceID = "i-00000000000000000"
+	}
+
+	source := newAWSCredentialSource(c)
+	handler := &imdsHandler{
+		source:  source,
+		opts:    opts,
+		started: time.Now().UTC(),
+		tokens:  map[string]time.Time{},
+	}
+	server, err := startCredentialServer(ctx, address, source, opts.RetryInterval, handler)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &IMDSServer{
+		URL:    fmt.Sprintf("http://%s", server.addr),
+		server: server,
+	}
+	klog.V(2).Infof("serving instance metadata credentials at %s", ret.URL)
+	return ret, nil
+}
+
+// Env returns the environment variables that point AWS SDKs and the AWS CLI at the server
+func (s *IMDSServer) Env() map[string]string {
+	return map[string]string{
+		"AWS_EC2_METADATA_SERVICE_ENDPOINT": s.URL,
+	}
+}
+
+// Close stops the server and the background refresh
+func (s *IMDSServer) Close() error {
+	s.server.close()
+	return nil
+}
+
+// imdsHandler serves the IMDSv2 endpoints
+type imdsHandler struct {
+	source  *awsCredentialSource
+	opts    IMDSServerOptions
+	started time.Time
+
+	mu     sync.Mutex
+	tokens map[string]time.Time
+}
+
+// ServeHTTP implements http.Handler
+func (h *imdsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/latest/api/token" {
+		h.serveToken(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.validToken(r.Header.Get("X-aws-ec2-metadata-token")) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	credentialsPath := "/latest/meta-data/iam/security-credentials/"
+	switch r.URL.Path {
+	case credentialsPath, strings.TrimSuffix(credentialsPath, "/"):
+		fmt.Fprint(w, h.opts.RoleName)
+	case credentialsPath + h.opts.RoleName:
+		h.serveCredentials(w, r)
+	case "/latest/dynamic/instance-identity/document":
+		writeJSON(w, h.identityDocument())
+	case "/latest/meta-data/placement/region":
+		fmt.Fprint(w, h.opts.Region)
+	case "/latest/meta-data/placement/availability-zone":
+		fmt.Fprint(w, h.opts.Region+"a")
+	case "/latest/meta-data/instance-id":
+		fmt.Fprint(w, h.opts.InstanceID)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveToken issues a session token valid for the number of seconds requested in the ttl header
+func (h *imdsHandler) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// like the real service, refuse requests relayed by a proxy, so a local proxy or an app that can
+	// be tricked into making requests cannot obtain a token
+	if r.Header.Get("X-Forwarded-For") != "" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	ttl, err := strconv.Atoi(r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds"))
+	if err != nil || ttl < 1 || ttl > maxIMDSTokenTTL {
+		http.Error(w, "invalid token ttl", http.StatusBadRequest)
+		return
+	}
+	token, err := randomHex(32)
+	if err != nil {
+		http.Error(w, "unable to create token", http.StatusInternalServerError)
+		return
+	}
+
+	h.mu.Lock()
+	now := time.Now()
+	for t, expires := range h.tokens {
+		if now.After(expires) {
+			delete(h.tokens, t)
+		}
+	}
+	h.tokens[token] = now.Add(time.Duration(ttl) * time.Second)
+	h.mu.Unlock()
+
+	w.Header().Set("X-aws-ec2-metadata-token-ttl-seconds", strconv.Itoa(ttl))
+	fmt.Fprint(w, token)
+}
+
+// validToken returns true if the token was issued by the server and has not expired
+func (h *imdsHandler) validToken(token string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	expires, ok := h.tokens[token]
+	return ok && time.Now().Before(expires)
+}
+
+// serveCredentials serves the current credentials of the source
+func (h *imdsHandler) serveCredentials(w http.ResponseWriter, r *http.Request) {
+	creds, err := h.source.get(r.Context())
+	if err != nil {
+		klog.Errorf("unable to serve aws credentials: %s", err.Error())
+		http.Error(w, "unable to get credentials from vault", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, imdsCredentials{
+		Code:            "Success",
+		LastUpdated:     creds.Created.UTC().Format(time.RFC3339),
+		Type:            "AWS-HMAC",
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		Token:           creds.SessionToken,
+		Expiration:      creds.expiresAt(h.source.config.BufferSeconds).UTC().Format(time.RFC3339),
+	})
+}
+
+// identityDocument returns the instance identity document of the emulated instance
+func (h *imdsHandler) identityDocument() imdsIdentityDocument {
+	return imdsIdentityDocument{
+		AccountID:        h.opts.AccountID,
+		Architecture:     "x86_64",
+		AvailabilityZone: h.opts.Region + "a",
+		ImageID:          "ami-00000000",
+		InstanceID:       h.opts.InstanceID,
+		InstanceType:     "t3.micro",
+		PendingTime:      h.started.Format(time.RFC3339),
+		PrivateIP:        "127.0.0.1",
+		Region:           h.opts.Region,
+		Version:          "2017-09-30",
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_StartIMDSServer(t *testing.T) {
+	c, _ := newSTSConfig(fakeResponse{body: stsResponse})
+	c.RoleARN = "arn:aws:iam::123456789012:role/admin"
+
+	server, err := c.StartIMDSServer(context.Background(), IMDSServerOptions{})
+	assert.NoError(t, err)
+	defer server.Close()
+
+	// the aws sdk talks to the server like it would to the real metadata service
+	sess := session.Must(session.NewSession())
+	client := ec2metadata.New(sess, &aws.Config{Endpoint: aws.String(server.URL)})
+	creds := ec2rolecreds.NewCredentialsWithClient(client)
+	value, err := creds.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "ASIA", value.AccessKeyID)
+	assert.Equal(t, "secret", value.SecretAccessKey)
+	assert.Equal(t, "token", value.SessionToken)
+	expiresAt, err := creds.ExpiresAt()
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(3570*time.Second), expiresAt, time.Minute)
+
+	doc, err := client.GetInstanceIdentityDocument()
+	assert.NoError(t, err)
+	assert.Equal(t, "123456789012", doc.AccountID)
+	assert.Equal(t, "us-east-1", doc.Region)
+
+	region, err := client.Region()
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-1", region)
+
+	// IMDSv1 requests without a session token are rejected
+	resp, err := http.Get(server.URL + "/latest/meta-data/iam/security-credentials/vault")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestIMDSHandler_token(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		ttl          string
+		forwardedFor string
+		wantStatus   int
+	}{
+		{name: "valid", method: http.MethodPut, ttl: "21600", wantStatus: http.StatusOK},
+		{name: "missing ttl", method: http.MethodPut, wantStatus: http.StatusBadRequest},
+		{name: "ttl too long", method: http.MethodPut, ttl: "21601", wantStatus: http.StatusBadRequest},
+		{name: "get", method: http.MethodGet, ttl: "60", wantStatus: http.StatusMethodNotAllowed},
+		{name: "forwarded", method: http.MethodPut, ttl: "60", forwardedFor: "10.0.0.1", wantStatus: http.StatusForbidden},
+	}
+	c, _ := newSTSConfig(fakeResponse{body: stsResponse})
+	server, err := c.StartIMDSServer(context.Background(), IMDSServerOptions{Address: "localhost:0"})
+	assert.NoError(t, err)
+	defer server.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, server.URL+"/latest/api/token", nil)
+			assert.NoError(t, err)
+			if tt.ttl != "" {
+				req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", tt.ttl)
+			}
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestConfig_StartIMDSServer_address(t *testing.T) {
+	c := Config{Transport: &fakeTransport{}}
+	_, err := c.StartIMDSServer(context.Background(), IMDSServerOptions{Address: "0.0.0.0:80"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not a loopback or link-local address")
+}
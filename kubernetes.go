@@ -0,0 +1,70 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// DefaultServiceAccountTokenPath is where kubernetes mounts the service account token in a pod
+const DefaultServiceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// KubernetesOptions configures a login with the kubernetes auth method
+type KubernetesOptions struct {
+	// Role is the vault role bound to the service account
+	Role string
+	// MountPath is the mount of the kubernetes auth method. Defaults to kubernetes.
+	MountPath string
+	// TokenPath is the file containing the service account JWT.
+	// Defaults to DefaultServiceAccountTokenPath.
+	TokenPath string
+}
+
+// KubernetesLogin logs in to vault with the service account token of the pod.
+// The returned token can be used by setting it as the Token of the config:
+//
+//	auth, err := c.KubernetesLogin(vaultutil.KubernetesOptions{Role: "my-controller"})
+//	if err != nil {
+//	    return err
+//	}
+//	c.Token = auth.ClientToken
+//	creds, err := c.NewAWSCredentials()
+func (c Config) KubernetesLogin(opts KubernetesOptions) (*Auth, error) {
+	return c.KubernetesLoginContext(context.Background(), opts)
+}
+
+// KubernetesLoginContext is like KubernetesLogin but cancels the request to vault when the context is done
+func (c Config) KubernetesLoginContext(ctx context.Context, opts KubernetesOptions) (*Auth, error) {
+	if opts.Role == "" {
+		return nil, fmt.Errorf("a vault role is required for kubernetes login")
+	}
+	tokenPath := opts.TokenPath
+	if tokenPath == "" {
+		tokenPath = DefaultServiceAccountTokenPath
+	}
+
+	jwt, err := ioutil.ReadFile(tokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading service account token: %w", err)
+	}
+
+	return c.login(ctx, mountPath(opts.MountPath, "kubernetes")+"/login", map[string]interface{}{
+		"role": opts.Role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+}
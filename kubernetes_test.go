@@ -0,0 +1,78 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_KubernetesLogin(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, ioutil.WriteFile(tokenPath, []byte("jwt.token\n"), 0600))
+
+	tests := []struct {
+		name     string
+		opts     KubernetesOptions
+		wantPath string
+		wantErr  bool
+	}{
+		{
+			name:     "custom mount",
+			opts:     KubernetesOptions{Role: "controller", MountPath: "k8s-prod", TokenPath: tokenPath},
+			wantPath: "auth/k8s-prod/login",
+		},
+		{
+			name:     "default mount",
+			opts:     KubernetesOptions{Role: "controller", TokenPath: tokenPath},
+			wantPath: "auth/kubernetes/login",
+		},
+		{
+			name:    "missing role",
+			opts:    KubernetesOptions{TokenPath: tokenPath},
+			wantErr: true,
+		},
+		{
+			name:    "missing token",
+			opts:    KubernetesOptions{Role: "controller", TokenPath: filepath.Join(t.TempDir(), "missing")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeTransport{responses: map[string]fakeResponse{
+				"POST " + tt.wantPath: {body: `{"auth":{"client_token":"s.k8s"}}`},
+				"POST aws/sts/admin":  {body: `{"lease_id":"123","data":{"access_key":"a","secret_key":"s","security_token":"t"}}`},
+			}}
+			c := Config{Path: "aws", Role: "admin", Transport: fake}
+
+			auth, err := c.KubernetesLogin(tt.opts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"role": "controller", "jwt": "jwt.token"}, fake.requests[0].Data)
+
+			c.Token = auth.ClientToken
+			_, err = c.AWSLogin()
+			assert.NoError(t, err)
+			assert.Equal(t, "s.k8s", fake.requests[1].Token)
+		})
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultOIDCPort is the port of the local callback listener. It matches the vault CLI.
+	DefaultOIDCPort = 8250
+	// DefaultOIDCTimeout is how long to wait for the user to complete an OIDC login
+	DefaultOIDCTimeout = 5 * time.Minute
+)
+
+// OIDCOptions configures a login with the oidc auth method
+type OIDCOptions struct {
+	// Role is the vault role to log in with. The default role of the mount is used when empty.
+	Role string
+	// MountPath is the mount of the oidc auth method. Defaults to oidc.
+	MountPath string
+	// ListenAddress is the address of the local callback listener, and the host
+	// of the redirect URI. Defaults to localhost.
+	ListenAddress string
+	// Port is the port of the local callback listener. Defaults to DefaultOIDCPort.
+	// The redirect URI http://<ListenAddress>:<Port>/oidc/callback must be allowed by the role.
+	Port int
+	// SkipBrowser only prints the login URL instead of opening it in a browser
+	SkipBrowser bool
+	// OpenBrowser opens the login URL. Defaults to OpenBrowser.
+	OpenBrowser func(url string) error
+	// Output is where the login URL is printed. Defaults to os.Stderr.
+	Output io.Writer
+	// Timeout is how long to wait for the login to complete. Defaults to DefaultOIDCTimeout.
+	Timeout time.Duration
+	// Persist stores the resulting token in ~/.vault-token
+	Persist bool
+}
+
+// oidcCallback holds the parameters passed to the redirect URI by the identity provider
+type oidcCallback struct {
+	state string
+	code  string
+	err   error
+	// result receives the outcome of exchanging the code, which is reported to the browser
+	result chan error
+}
+
+// OIDCLogin logs in to vault with the oidc auth method. It requests an authorization URL from vault,
+// opens it in a browser (or prints it), and waits for the identity provider to redirect to a
+// local callback listener before exchanging the code for a vault token.
+func (c Config) OIDCLogin(opts OIDCOptions) (*Auth, error) {
+	return c.OIDCLoginContext(context.Background(), opts)
+}
+
+// OIDCLoginContext is like OIDCLogin but stops waiting for the login when the context is done
+func (c Config) OIDCLoginContext(ctx context.Context, opts OIDCOptions) (*Auth, error) {
+	host := opts.ListenAddress
+	if host == "" {
+		host = "localhost"
+	}
+	port := opts.Port
+	if port == 0 {
+		port = DefaultOIDCPort
+	}
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DefaultOIDCTimeout
+	}
+	out := opts.Output
+	if out == nil {
+		out = os.Stderr
+	}
+	mount := mountPath(opts.MountPath, "oidc")
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("error starting oidc callback listener: %w", err)
+	}
+	defer listener.Close()
+	// use the port of the listener in case an ephemeral port was requested
+	port = listener.Addr().(*net.TCPAddr).Port
+	redirectURI := fmt.Sprintf("http://%s/oidc/callback", net.JoinHostPort(host, strconv.Itoa(port)))
+
+	nonce, err := randomHex(20)
+	if err != nil {
+		return nil, err
+	}
+
+	authURL, err := c.oidcAuthURL(ctx, mount, opts.Role, redirectURI, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	callbacks := make(chan oidcCallback, 1)
+	server := &http.Server{Handler: oidcCallbackHandler(callbacks)}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			klog.V(3).Infof("oidc callback listener stopped: %s", err.Error())
+		}
+	}()
+	defer func() {
+		// let the callback handler finish reporting the result to the browser
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			server.Close()
+		}
+	}()
+
+	fmt.Fprintf(out, "Complete the login via your OIDC provider. Open the following URL in your browser:\n\n    %s\n\n", authURL)
+	if !opts.SkipBrowser {
+		open := opts.OpenBrowser
+		if open == nil {
+			open = OpenBrowser
+		}
+		// the callback is answered only after the login completes, so never wait for the browser
+		go func() {
+			if err := open(authURL); err != nil {
+				klog.V(2).Infof("unable to open browser: %s", err.Error())
+			}
+		}()
+	}
+	fmt.Fprintf(out, "Waiting for OIDC authentication to complete...\n")
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var callback oidcCallback
+	select {
+	case <-ctx.Done():
+		return nil, timeoutError(ctx, "oidc login", ctx.Err())
+	case callback = <-callbacks:
+	}
+	if callback.err != nil {
+		return nil, callback.err
+	}
+
+	auth, err := c.oidcCallback(ctx, mount, callback, nonce, opts.Persist)
+	callback.result <- err
+	return auth, err
+}
+
+// oidcCallback exchanges the code of the identity provider for a vault token
+func (c Config) oidcCallback(ctx context.Context, mount string, callback oidcCallback, nonce string, persist bool) (*Auth, error) {
+	data, err := c.request(ctx, http.MethodGet, mount+"/oidc/callback", map[string]interface{}{
+		"state":        callback.state,
+		"code":         callback.code,
+		"client_nonce": nonce,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("vault oidc callback failed: %w", err)
+	}
+	auth, err := parseAuth(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if persist {
+		if err := PersistToken(auth.ClientToken); err != nil {
+			return nil, err
+		}
+	}
+	return auth, nil
+}
+
+// oidcAuthURL asks vault for the URL of the identity provider to send the user to
+func (c Config) oidcAuthURL(ctx context.Context, mount, role, redirectURI, nonce string) (string, error) {
+	data, err := c.request(ctx, http.MethodPost, mount+"/oidc/auth_url", map[string]interface{}{
+		"role":         role,
+		"redirect_uri": redirectURI,
+		"client_nonce": nonce,
+	})
+	if err != nil {
+		return "", fmt.Errorf("vault oidc auth url failed: %w", err)
+	}
+
+	resp := struct {
+		Data struct {
+			AuthURL string `json:"auth_url"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return "", fmt.Errorf("error unmarshaling vault oidc auth url: %s", err.Error())
+	}
+	if resp.Data.AuthURL == "" {
+		return "", fmt.Errorf("vault did not return an oidc auth url, check that %s is an allowed redirect uri of the role", redirectURI)
+	}
+	return resp.Data.AuthURL, nil
+}
+
+// oidcCallbackHandler sends the first callback received from the identity provider to the channel.
+// The browser is told whether the login succeeded once vault accepted or rejected the code.
+func oidcCallbackHandler(callbacks chan<- oidcCallback) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oidc/callback", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		callback := oidcCallback{
+			state:  q.Get("state"),
+			code:   q.Get("code"),
+			result: make(chan error, 1),
+		}
+		if e := q.Get("error"); e != "" {
+			callback.err = fmt.Errorf("oidc provider returned an error: %s: %s", e, q.Get("error_description"))
+		} else if callback.code == "" {
+			callback.err = fmt.Errorf("oidc callback did not include a code")
+		}
+
+		select {
+		case callbacks <- callback:
+		default:
+			http.Error(w, "A login callback was already received. Return to the terminal.", http.StatusConflict)
+			return
+		}
+		if callback.err != nil {
+			http.Error(w, callback.err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case err := <-callback.result:
+			if err != nil {
+				http.Error(w, "Vault login failed. Return to the terminal for details.", http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprint(w, "Vault login successful. You can close this window and return to the terminal.")
+		case <-r.Context().Done():
+		}
+	})
+	return mux
+}
+
+// OpenBrowser opens the url in the default browser of the user
+func OpenBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// freePort returns a local port that is not in use
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestConfig_OIDCLogin(t *testing.T) {
+	tests := []struct {
+		name        string
+		callback    string
+		vaultErr    error
+		skipBrowser bool
+		wantErr     error
+		wantStatus  int
+		wantBody    string
+	}{
+		{
+			name:       "success",
+			callback:   "?state=st&code=abc",
+			wantStatus: http.StatusOK,
+			wantBody:   "Vault login successful",
+		},
+		{
+			name:       "provider error",
+			callback:   "?error=access_denied&error_description=nope",
+			wantErr:    fmt.Errorf("oidc provider returned an error: access_denied: nope"),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "access_denied",
+		},
+		{
+			name:       "vault rejects the code",
+			callback:   "?state=st&code=abc",
+			vaultErr:   fmt.Errorf("GET auth/oidc/oidc/callback returned status 400: expired or missing OAuth state"),
+			wantErr:    fmt.Errorf("vault oidc callback failed: GET auth/oidc/oidc/callback returned status 400: expired or missing OAuth state"),
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "Vault login failed",
+		},
+		{
+			name:        "timeout",
+			skipBrowser: true,
+			wantErr:     ErrTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeTransport{responses: map[string]fakeResponse{
+				"POST auth/oidc/oidc/auth_url": {body: `{"data":{"auth_url":"https://idp.example.com/auth"}}`},
+				"GET auth/oidc/oidc/callback":  {body: `{"auth":{"client_token":"s.oidc"}}`, err: tt.vaultErr},
+			}}
+			out := &bytes.Buffer{}
+			port := freePort(t)
+			redirect := fmt.Sprintf("http://127.0.0.1:%d/oidc/callback", port)
+			browser := make(chan browserResult, 1)
+
+			auth, err := Config{Transport: fake}.OIDCLogin(OIDCOptions{
+				Role:          "dev",
+				ListenAddress: "127.0.0.1",
+				Port:          port,
+				SkipBrowser:   tt.skipBrowser,
+				Timeout:       500 * time.Millisecond,
+				Output:        out,
+				OpenBrowser: func(url string) error {
+					// act as the browser following the redirect of the identity provider
+					browser <- getBrowserResult(url, redirect+tt.callback)
+					return nil
+				},
+			})
+			assert.Contains(t, out.String(), "https://idp.example.com/auth")
+			assert.Equal(t, redirect, fake.requests[0].Data["redirect_uri"])
+
+			if tt.skipBrowser {
+				assert.Len(t, browser, 0)
+			} else {
+				// the browser sees the result of the login, not just the arrival of the code
+				got := <-browser
+				assert.NoError(t, got.err)
+				assert.Equal(t, "https://idp.example.com/auth", got.opened)
+				assert.Equal(t, tt.wantStatus, got.status)
+				assert.Contains(t, got.body, tt.wantBody)
+			}
+
+			if tt.wantErr != nil {
+				assert.Error(t, err)
+				if errors.Is(tt.wantErr, ErrTimeout) {
+					assert.True(t, errors.Is(err, ErrTimeout))
+				} else {
+					assert.EqualError(t, err, tt.wantErr.Error())
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "s.oidc", auth.ClientToken)
+
+			callback := fake.requests[1].Data
+			assert.Equal(t, "st", callback["state"])
+			assert.Equal(t, "abc", callback["code"])
+			assert.Equal(t, fake.requests[0].Data["client_nonce"], callback["client_nonce"])
+		})
+	}
+}
+
+// browserResult is what the fake browser of TestConfig_OIDCLogin saw
+type browserResult struct {
+	opened string
+	status int
+	body   string
+	err    error
+}
+
+// getBrowserResult requests the callback url like a browser redirected by the identity provider
+func getBrowserResult(opened, callback string) browserResult {
+	resp, err := http.Get(callback)
+	if err != nil {
+		return browserResult{opened: opened, err: err}
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return browserResult{opened: opened, status: resp.StatusCode, body: string(body), err: err}
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultInitialBackoff is the delay before the first retry when RetryPolicy.InitialBackoff is not set
+	DefaultInitialBackoff = 250 * time.Millisecond
+	// DefaultMaxBackoff is the longest delay between retries when RetryPolicy.MaxBackoff is not set
+	DefaultMaxBackoff = 10 * time.Second
+)
+
+// RetryPolicy controls how requests that fail with a transient error, such as a rate limit,
+// a sealed or standby node, a server error or a network failure, are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	// Retries are disabled when it is less than two.
+	MaxAttempts int
+	// MaxElapsed stops retrying once this much time has passed since the first attempt.
+	// Zero means no limit other than MaxAttempts and the context.
+	MaxElapsed time.Duration
+	// InitialBackoff is the delay before the first retry. It doubles with every attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff is the longest delay between two attempts
+	MaxBackoff time.Duration
+	// Jitter is the fraction of each delay, between 0 and 1, that is randomized
+	// so that many clients do not retry at the same time.
+	Jitter float64
+}
+
+// DefaultRetryPolicy returns a retry policy suitable for riding out a vault failover
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		MaxElapsed:     time.Minute,
+		InitialBackoff: DefaultInitialBackoff,
+		MaxBackoff:     DefaultMaxBackoff,
+		Jitter:         0.2,
+	}
+}
+
+// do calls fn until it succeeds, returns an error that should not be retried, or the policy is exhausted
+func (p RetryPolicy) do(ctx context.Context, op string, idempotent bool, fn func(context.Context) ([]byte, error)) ([]byte, error) {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		ret, err := fn(ctx)
+		if err == nil {
+			return ret, nil
+		}
+		if attempt >= p.MaxAttempts || ctx.Err() != nil || !retryable(err, idempotent) {
+			return nil, err
+		}
+
+		delay := p.backoff(attempt)
+		if p.MaxElapsed > 0 && time.Since(start)+delay > p.MaxElapsed {
+			return nil, err
+		}
+		klog.V(2).Infof("%s failed on attempt %d of %d, retrying in %s: %s", op, attempt, p.MaxAttempts, delay, err.Error())
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, timeoutError(ctx, op, err)
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = DefaultInitialBackoff
+	}
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = DefaultMaxBackoff
+	}
+
+	delay := initial
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+
+	if p.Jitter > 0 {
+		jitter := time.Duration(p.Jitter * float64(delay) * (rand.Float64()*2 - 1)) //nolint:gosec // not used for security
+		delay += jitter
+	}
+	return delay
+}
+
+// retryable returns true if a failed request should be attempted again.
+// Rate limiting and unavailable nodes are always retried, since vault rejected the request
+// without processing it. Server errors, timeouts and dropped connections may have happened
+// after vault acted on the request, so they are only retried for idempotent requests.
+func retryable(err error, idempotent bool) bool {
+	var respErr *ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return true
+		case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+			return idempotent
+		}
+		return false
+	}
+
+	var connErr *ConnectionError
+	if errors.As(err, &connErr) {
+		if connErr.TLS {
+			return false
+		}
+		if idempotent {
+			return true
+		}
+		var opErr *net.OpError
+		return errors.As(err, &opErr) && opErr.Op == "dial"
+	}
+
+	return idempotent && errors.Is(err, ErrTimeout)
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sequenceTransport returns the errors in order, then succeeds with body
+type sequenceTransport struct {
+	errs     []error
+	body     string
+	attempts int
+}
+
+func (s *sequenceTransport) Do(ctx context.Context, req *Request) ([]byte, error) {
+	s.attempts++
+	if s.attempts <= len(s.errs) {
+		return nil, s.errs[s.attempts-1]
+	}
+	return []byte(s.body), nil
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	sealed := &ResponseError{StatusCode: 503, Errors: []string{"Vault is sealed"}}
+	serverErr := &ResponseError{StatusCode: 500, Errors: []string{"internal error"}}
+	denied := &ResponseError{StatusCode: 403, Errors: []string{"permission denied"}}
+	dial := &ConnectionError{Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+	reset := &ConnectionError{Err: &net.OpError{Op: "read", Err: errors.New("connection reset")}}
+
+	tests := []struct {
+		name         string
+		policy       RetryPolicy
+		errs         []error
+		azure        bool
+		wantAttempts int
+		wantErr      bool
+	}{
+		{
+			name:         "disabled",
+			errs:         []error{sealed},
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "recovers from sealed vault",
+			policy:       policy,
+			errs:         []error{sealed, sealed},
+			wantAttempts: 3,
+		},
+		{
+			name:         "gives up after max attempts",
+			policy:       policy,
+			errs:         []error{sealed, sealed, sealed},
+			wantAttempts: 3,
+			wantErr:      true,
+		},
+		{
+			name:         "permission denied is not retried",
+			policy:       policy,
+			errs:         []error{denied},
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "server error retried for sts",
+			policy:       policy,
+			errs:         []error{serverErr},
+			wantAttempts: 2,
+		},
+		{
+			name:         "server error not retried for azure",
+			policy:       policy,
+			errs:         []error{serverErr},
+			azure:        true,
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "refused connection retried for azure",
+			policy:       policy,
+			errs:         []error{dial},
+			azure:        true,
+			wantAttempts: 2,
+		},
+		{
+			name:         "reset connection not retried for azure",
+			policy:       policy,
+			errs:         []error{reset},
+			azure:        true,
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "max elapsed",
+			policy:       RetryPolicy{MaxAttempts: 3, MaxElapsed: time.Millisecond, InitialBackoff: time.Second},
+			errs:         []error{sealed},
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &sequenceTransport{
+				errs: tt.errs,
+				body: `{"lease_id":"123","data":{"access_key":"a","secret_key":"s","security_token":"t","client_id":"id","client_secret":"s"}}`,
+			}
+			c := Config{Path: "secret", Role: "admin", Transport: transport, Retry: tt.policy}
+
+			var err error
+			if tt.azure {
+				_, err = c.AzureLogin()
+			} else {
+				_, err = c.AWSLogin()
+			}
+			assert.Equal(t, tt.wantAttempts, transport.attempts)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, p.backoff(1))
+	assert.Equal(t, 2*time.Second, p.backoff(2))
+	assert.Equal(t, 4*time.Second, p.backoff(3))
+	assert.Equal(t, 5*time.Second, p.backoff(4))
+
+	p.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		got := p.backoff(1)
+		assert.True(t, got >= 500*time.Millisecond && got <= 1500*time.Millisecond)
+	}
+}
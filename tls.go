@@ -0,0 +1,202 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// TLSConfig holds the TLS options used to connect to vault. Empty fields fall back to the
+// environment variables used by the vault CLI, which are listed next to each field.
+type TLSConfig struct {
+	// CACert is a PEM encoded CA certificate file used to verify the vault server (VAULT_CACERT)
+	CACert string
+	// CAPath is a directory of PEM encoded CA certificates used to verify the vault server (VAULT_CAPATH)
+	CAPath string
+	// ClientCert is a PEM encoded client certificate for mTLS (VAULT_CLIENT_CERT)
+	ClientCert string
+	// ClientKey is the PEM encoded private key of ClientCert (VAULT_CLIENT_KEY)
+	ClientKey string
+	// ServerName is the name used for SNI and certificate verification (VAULT_TLS_SERVER_NAME)
+	ServerName string
+	// Insecure disables verification of the vault server certificate.
+	// It should only be used for development. (VAULT_SKIP_VERIFY)
+	Insecure bool
+}
+
+// withEnv returns a copy of the TLS config with empty fields populated from the environment
+func (t TLSConfig) withEnv() TLSConfig {
+	if t.CACert == "" {
+		t.CACert = os.Getenv("VAULT_CACERT")
+	}
+	if t.CAPath == "" {
+		t.CAPath = os.Getenv("VAULT_CAPATH")
+	}
+	if t.ClientCert == "" {
+		t.ClientCert = os.Getenv("VAULT_CLIENT_CERT")
+	}
+	if t.ClientKey == "" {
+		t.ClientKey = os.Getenv("VAULT_CLIENT_KEY")
+	}
+	if t.ServerName == "" {
+		t.ServerName = os.Getenv("VAULT_TLS_SERVER_NAME")
+	}
+	if !t.Insecure {
+		t.Insecure, _ = strconv.ParseBool(os.Getenv("VAULT_SKIP_VERIFY"))
+	}
+	return t
+}
+
+// clientConfig builds a crypto/tls config from the options
+func (t TLSConfig) clientConfig() (*tls.Config, error) {
+	ret := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.Insecure, //nolint:gosec // opt-in for development
+	}
+
+	if t.CACert != "" || t.CAPath != "" {
+		pool := x509.NewCertPool()
+		if t.CACert != "" {
+			if err := appendCertFile(pool, t.CACert); err != nil {
+				return nil, err
+			}
+		}
+		if t.CAPath != "" {
+			files, err := ioutil.ReadDir(t.CAPath)
+			if err != nil {
+				return nil, fmt.Errorf("error reading vault CA path %s: %w", t.CAPath, err)
+			}
+			// like the vault client, skip files that are not certificates, such as READMEs,
+			// and only fail when the directory holds no certificate at all
+			loaded := 0
+			for _, f := range files {
+				if f.IsDir() {
+					continue
+				}
+				if err := appendCertFile(pool, filepath.Join(t.CAPath, f.Name())); err != nil {
+					klog.V(3).Infof("skipping vault CA path entry: %s", err.Error())
+					continue
+				}
+				loaded++
+			}
+			if loaded == 0 {
+				return nil, fmt.Errorf("no PEM certificates found in vault CA path %s", t.CAPath)
+			}
+		}
+		ret.RootCAs = pool
+	}
+
+	if t.ClientCert != "" || t.ClientKey != "" {
+		if t.ClientCert == "" || t.ClientKey == "" {
+			return nil, fmt.Errorf("both a vault client certificate and key must be provided")
+		}
+		cert, err := tls.LoadX509KeyPair(t.ClientCert, t.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading vault client certificate: %w", err)
+		}
+		ret.Certificates = []tls.Certificate{cert}
+	}
+
+	return ret, nil
+}
+
+// fileVersion describes the modification time and size of every certificate and key file. It changes
+// when the files are rotated, so clients built from the old files can be replaced.
+func (t TLSConfig) fileVersion() string {
+	files := []string{t.CACert, t.ClientCert, t.ClientKey}
+	if t.CAPath != "" {
+		entries, err := ioutil.ReadDir(t.CAPath)
+		if err == nil {
+			for _, entry := range entries {
+				files = append(files, filepath.Join(t.CAPath, entry.Name()))
+			}
+		}
+	}
+
+	var b strings.Builder
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		// stat follows symlinks, so rotating the target of a mounted secret is noticed too
+		info, err := os.Stat(file)
+		if err != nil {
+			fmt.Fprintf(&b, "%s missing\n", file)
+			continue
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", file, info.ModTime().UnixNano(), info.Size())
+	}
+	return b.String()
+}
+
+// cliArgs returns the vault CLI flags matching the options
+func (t TLSConfig) cliArgs() []string {
+	var args []string
+	if t.CACert != "" {
+		args = append(args, fmt.Sprintf("-ca-cert=%s", t.CACert))
+	}
+	if t.CAPath != "" {
+		args = append(args, fmt.Sprintf("-ca-path=%s", t.CAPath))
+	}
+	if t.ClientCert != "" {
+		args = append(args, fmt.Sprintf("-client-cert=%s", t.ClientCert))
+	}
+	if t.ClientKey != "" {
+		args = append(args, fmt.Sprintf("-client-key=%s", t.ClientKey))
+	}
+	if t.ServerName != "" {
+		args = append(args, fmt.Sprintf("-tls-server-name=%s", t.ServerName))
+	}
+	if t.Insecure {
+		args = append(args, "-tls-skip-verify")
+	}
+	return args
+}
+
+// appendCertFile adds the PEM certificates in a file to the pool
+func appendCertFile(pool *x509.CertPool, file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading vault CA certificate %s: %w", file, err)
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no PEM certificates found in vault CA certificate %s", file)
+	}
+	return nil
+}
+
+// isTLSError returns true if the error happened while negotiating TLS with the server
+func isTLSError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var header tls.RecordHeaderError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostname) ||
+		errors.As(err, &invalid) || errors.As(err, &header) {
+		return true
+	}
+	return strings.Contains(err.Error(), "tls: ")
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_TLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":{"ttl":3600}}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caDir := filepath.Join(dir, "ca")
+	assert.NoError(t, os.Mkdir(caDir, 0700))
+	caFile := filepath.Join(caDir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(t, ioutil.WriteFile(caFile, caPEM, 0600))
+	// CA directories usually hold more than certificates
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(caDir, "README"), []byte("not a certificate"), 0600))
+	assert.NoError(t, os.Symlink(filepath.Join(dir, "missing.pem"), filepath.Join(caDir, "0a1b2c3d.0")))
+	emptyDir := filepath.Join(dir, "empty")
+	assert.NoError(t, os.Mkdir(emptyDir, 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(emptyDir, "README"), []byte("not a certificate"), 0600))
+
+	defer os.Setenv("VAULT_ADDR", os.Getenv("VAULT_ADDR"))
+	os.Setenv("VAULT_ADDR", server.URL)
+
+	tests := []struct {
+		name    string
+		tls     TLSConfig
+		wantErr string
+		wantTLS bool
+	}{
+		{
+			name: "ca cert",
+			tls:  TLSConfig{CACert: caFile},
+		},
+		{
+			name: "ca path",
+			tls:  TLSConfig{CAPath: caDir},
+		},
+		{
+			name: "insecure",
+			tls:  TLSConfig{Insecure: true},
+		},
+		{
+			name:    "unknown authority",
+			tls:     TLSConfig{},
+			wantErr: "tls handshake with vault",
+			wantTLS: true,
+		},
+		{
+			name:    "wrong server name",
+			tls:     TLSConfig{CACert: caFile, ServerName: "vault.internal"},
+			wantErr: "tls handshake with vault",
+			wantTLS: true,
+		},
+		{
+			name:    "missing client key",
+			tls:     TLSConfig{ClientCert: caFile},
+			wantErr: "both a vault client certificate and key must be provided",
+		},
+		{
+			name:    "ca path without certificates",
+			tls:     TLSConfig{CAPath: emptyDir},
+			wantErr: "no PEM certificates found in vault CA path",
+		},
+		{
+			name:    "missing ca file",
+			tls:     TLSConfig{CACert: filepath.Join(dir, "missing.pem")},
+			wantErr: "error reading vault CA certificate",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{TLS: tt.tls}
+			err := c.CheckToken()
+			if tt.wantErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				assert.Equal(t, tt.wantTLS, errors.Is(err, ErrTLSHandshake))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestTLSConfig_cliArgs(t *testing.T) {
+	got := TLSConfig{CACert: "ca.pem", ServerName: "vault", Insecure: true}.cliArgs()
+	assert.Equal(t, []string{"-ca-cert=ca.pem", "-tls-server-name=vault", "-tls-skip-verify"}, got)
+}
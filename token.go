@@ -0,0 +1,212 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultRenewFraction is the fraction of the token TTL after which the watcher renews it
+	DefaultRenewFraction = 2.0 / 3.0
+	// DefaultRenewRetryInterval is how long the watcher waits before retrying a failed renewal
+	DefaultRenewRetryInterval = 10 * time.Second
+)
+
+// TokenEventType describes what happened to a watched token
+type TokenEventType int
+
+const (
+	// TokenRenewed is sent after every successful renewal
+	TokenRenewed TokenEventType = iota
+	// TokenRenewFailed is sent when a renewal fails. The watcher retries until the token expires.
+	TokenRenewFailed
+	// TokenMaxTTLReached is sent when vault renewed the token for less than the requested
+	// increment because it reached its max TTL. The token will not be renewed again.
+	TokenMaxTTLReached
+	// TokenReloginRequired is sent when the token is about to expire and cannot be renewed,
+	// or was rejected by vault. The watcher stops after sending it.
+	TokenReloginRequired
+)
+
+// String returns the name of the event type
+func (t TokenEventType) String() string {
+	switch t {
+	case TokenRenewed:
+		return "renewed"
+	case TokenRenewFailed:
+		return "renewal failed"
+	case TokenMaxTTLReached:
+		return "max ttl reached"
+	case TokenReloginRequired:
+		return "relogin required"
+	}
+	return fmt.Sprintf("unknown (%d)", int(t))
+}
+
+// TokenEvent is sent by WatchToken
+type TokenEvent struct {
+	// Type is what happened to the token
+	Type TokenEventType
+	// Auth is the result of the renewal for TokenRenewed and TokenMaxTTLReached events
+	Auth *Auth
+	// Err is the renewal error for TokenRenewFailed and TokenReloginRequired events
+	Err error
+}
+
+// TokenWatcherOptions configures WatchToken
+type TokenWatcherOptions struct {
+	// Increment is the number of seconds requested on each renewal.
+	// Defaults to the TTL the token was created with.
+	Increment int64
+	// RenewFraction is the fraction of the TTL after which the token is renewed.
+	// Defaults to DefaultRenewFraction.
+	RenewFraction float64
+	// RetryInterval is how long to wait before retrying a failed renewal.
+	// Defaults to DefaultRenewRetryInterval.
+	RetryInterval time.Duration
+}
+
+// RenewToken renews the token used by the config. An increment of zero lets vault
+// use the default TTL of the token.
+func (c Config) RenewToken(increment int64) (*Auth, error) {
+	return c.RenewTokenContext(context.Background(), increment)
+}
+
+// RenewTokenContext is like RenewToken but cancels the request to vault when the context is done
+func (c Config) RenewTokenContext(ctx context.Context, increment int64) (*Auth, error) {
+	data := map[string]interface{}{}
+	if increment > 0 {
+		data["increment"] = increment
+	}
+	resp, err := c.idempotentRequest(ctx, http.MethodPost, "auth/token/renew-self", data)
+	if err != nil {
+		return nil, fmt.Errorf("vault token renewal failed: %w", err)
+	}
+	return parseAuth(resp)
+}
+
+// WatchToken renews the token used by the config in the background, at a fraction of its TTL, and
+// reports what happens on the returned channel. The channel is closed when the watcher stops, either
+// because the context is done or after a TokenReloginRequired event. Tokens that do not expire are
+// not renewed, and the channel is closed once the context is done.
+func (c Config) WatchToken(ctx context.Context, opts TokenWatcherOptions) (<-chan TokenEvent, error) {
+	token, err := c.LookupTokenContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan TokenEvent, 1)
+	go c.watchToken(ctx, opts, token, events)
+	return events, nil
+}
+
+// watchToken is the renewal loop of WatchToken
+func (c Config) watchToken(ctx context.Context, opts TokenWatcherOptions, token *Token, events chan<- TokenEvent) {
+	defer close(events)
+
+	if token.NonExpiring() {
+		klog.V(3).Info("vault token does not expire - nothing to renew")
+		<-ctx.Done()
+		return
+	}
+
+	fraction := opts.RenewFraction
+	if fraction <= 0 || fraction >= 1 {
+		fraction = DefaultRenewFraction
+	}
+	retryInterval := opts.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = DefaultRenewRetryInterval
+	}
+	increment := opts.Increment
+	if increment == 0 {
+		increment = token.Data.CreationTTL
+	}
+
+	send := func(e TokenEvent) bool {
+		klog.V(3).Infof("vault token watcher: %s", e.Type)
+		select {
+		case events <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	wait := func(d time.Duration) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	ttl := time.Duration(token.Data.TTL) * time.Second
+	expires := time.Now().Add(ttl)
+	renewable := token.Data.Renewable
+	next := time.Duration(float64(ttl) * fraction)
+
+	for {
+		if !wait(next) {
+			return
+		}
+		if !renewable {
+			send(TokenEvent{Type: TokenReloginRequired})
+			return
+		}
+
+		auth, err := c.RenewTokenContext(ctx, increment)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if !send(TokenEvent{Type: TokenRenewFailed, Err: err}) {
+				return
+			}
+			remaining := time.Until(expires)
+			if errors.Is(err, ErrPermissionDenied) || remaining <= 0 {
+				send(TokenEvent{Type: TokenReloginRequired, Err: err})
+				return
+			}
+			next = retryInterval
+			if remaining < next {
+				next = remaining
+			}
+			continue
+		}
+
+		ttl = time.Duration(auth.LeaseDuration) * time.Second
+		expires = time.Now().Add(ttl)
+		next = time.Duration(float64(ttl) * fraction)
+		if !send(TokenEvent{Type: TokenRenewed, Auth: auth}) {
+			return
+		}
+		if !auth.Renewable || (increment > 0 && auth.LeaseDuration < increment) {
+			renewable = false
+			if !send(TokenEvent{Type: TokenMaxTTLReached, Auth: auth}) {
+				return
+			}
+		}
+	}
+}
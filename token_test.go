@@ -0,0 +1,117 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_RenewToken(t *testing.T) {
+	fake := &fakeTransport{responses: map[string]fakeResponse{
+		"POST auth/token/renew-self": {body: `{"auth":{"client_token":"s.token","lease_duration":3600,"renewable":true}}`},
+	}}
+	auth, err := Config{Transport: fake}.RenewToken(3600)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3600), auth.LeaseDuration)
+	assert.Equal(t, map[string]interface{}{"increment": int64(3600)}, fake.requests[0].Data)
+}
+
+func TestConfig_WatchToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		lookup string
+		renew  []fakeResponse
+		want   []TokenEventType
+	}{
+		{
+			name:   "renewed until max ttl",
+			lookup: `{"data":{"ttl":1,"creation_ttl":1,"renewable":true}}`,
+			renew: []fakeResponse{
+				{body: `{"auth":{"client_token":"s.token","lease_duration":1,"renewable":true}}`},
+				{body: `{"auth":{"client_token":"s.token","lease_duration":0,"renewable":true}}`},
+			},
+			want: []TokenEventType{TokenRenewed, TokenRenewed, TokenMaxTTLReached, TokenReloginRequired},
+		},
+		{
+			name:   "renewal fails then recovers",
+			lookup: `{"data":{"ttl":1,"creation_ttl":1,"renewable":true}}`,
+			renew: []fakeResponse{
+				{err: &ResponseError{StatusCode: 500}},
+				{body: `{"auth":{"client_token":"s.token","lease_duration":1,"renewable":false}}`},
+			},
+			want: []TokenEventType{TokenRenewFailed, TokenRenewed, TokenMaxTTLReached, TokenReloginRequired},
+		},
+		{
+			name:   "token revoked",
+			lookup: `{"data":{"ttl":1,"creation_ttl":1,"renewable":true}}`,
+			renew: []fakeResponse{
+				{err: &ResponseError{StatusCode: 403, Errors: []string{"permission denied"}}},
+			},
+			want: []TokenEventType{TokenRenewFailed, TokenReloginRequired},
+		},
+		{
+			name:   "not renewable",
+			lookup: `{"data":{"ttl":1,"renewable":false}}`,
+			want:   []TokenEventType{TokenReloginRequired},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renewals := 0
+			transport := transportFunc(func(ctx context.Context, req *Request) ([]byte, error) {
+				if req.Path == "auth/token/lookup-self" {
+					return []byte(tt.lookup), nil
+				}
+				if renewals >= len(tt.renew) {
+					return nil, fmt.Errorf("unexpected renewal")
+				}
+				resp := tt.renew[renewals]
+				renewals++
+				return []byte(resp.body), resp.err
+			})
+			c := Config{Transport: transport}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			events, err := c.WatchToken(ctx, TokenWatcherOptions{RenewFraction: 0.1, RetryInterval: 10 * time.Millisecond})
+			assert.NoError(t, err)
+
+			var got []TokenEventType
+			for e := range events {
+				got = append(got, e.Type)
+			}
+			assert.Equal(t, tt.want, got)
+			assert.NoError(t, ctx.Err())
+		})
+	}
+}
+
+func TestConfig_WatchToken_cancel(t *testing.T) {
+	c := Config{Transport: &fakeTransport{responses: map[string]fakeResponse{
+		"GET auth/token/lookup-self": {body: `{"data":{"ttl":0,"expire_time":null}}`},
+	}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := c.WatchToken(ctx, TokenWatcherOptions{})
+	assert.NoError(t, err)
+
+	cancel()
+	_, open := <-events
+	assert.False(t, open)
+}
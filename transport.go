@@ -0,0 +1,275 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// DefaultVaultAddr is the address used when VAULT_ADDR is not set.
+// It matches the default of the vault CLI.
+const DefaultVaultAddr = "https://127.0.0.1:8200"
+
+// MethodList is the non-standard HTTP method vault uses to list keys
+const MethodList = "LIST"
+
+// Request is a single call to the Vault API
+type Request struct {
+	// Method is the HTTP method of the request, e.g. GET, PUT or LIST
+	Method string
+	// Path is relative to the /v1/ prefix of the API, e.g. aws/sts/admin
+	Path string
+	// Data is sent as a JSON body, except for GET and LIST where it is sent as query parameters
+	Data map[string]interface{}
+	// Token overrides the token of the transport when not empty
+	Token string
+	// Namespace is the Vault Enterprise namespace of the request, if any
+	Namespace string
+}
+
+// Transport issues requests against the Vault API and returns the raw JSON response.
+// Every Vault call made by a Config goes through its Transport, so tests can replace
+// it with a fake that records requests and replays canned responses.
+type Transport interface {
+	Do(ctx context.Context, req *Request) ([]byte, error)
+}
+
+// HTTPTransport talks to the Vault HTTP API directly. It is the default transport.
+type HTTPTransport struct {
+	// Address is the vault server address, e.g. https://vault.example.com:8200
+	Address string
+	// Token is sent as the X-Vault-Token header when not empty
+	Token string
+	// Client is the http client used for requests
+	Client *http.Client
+}
+
+// NewHTTPTransport returns a transport configured from the same environment as the vault CLI
+func NewHTTPTransport() *HTTPTransport {
+	return &HTTPTransport{
+		Address: vaultAddr(),
+		Token:   vaultToken(),
+		Client:  &http.Client{},
+	}
+}
+
+// vaultAddr returns the address in VAULT_ADDR, or DefaultVaultAddr
+func vaultAddr() string {
+	address := os.Getenv("VAULT_ADDR")
+	if address == "" {
+		address = DefaultVaultAddr
+	}
+	return strings.TrimSuffix(address, "/")
+}
+
+// vaultToken returns the token in VAULT_TOKEN, or the one stored in ~/.vault-token
+// by the default token helper after a vault login.
+func vaultToken() string {
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		klog.V(5).Infof("unable to find home directory: %s", err.Error())
+		return ""
+	}
+	data, err := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+	if err != nil {
+		klog.V(5).Infof("unable to read vault token file: %s", err.Error())
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Do sends the request to the vault api and returns the body of the response
+func (h *HTTPTransport) Do(ctx context.Context, r *Request) ([]byte, error) {
+	u, err := url.Parse(fmt.Sprintf("%s/v1/%s", h.Address, strings.TrimPrefix(r.Path, "/")))
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader
+	if r.Method == http.MethodGet || r.Method == MethodList {
+		q := u.Query()
+		for k, v := range r.Data {
+			q.Set(k, fmt.Sprintf("%v", v))
+		}
+		u.RawQuery = q.Encode()
+	} else if r.Data != nil {
+		payload, err := json.Marshal(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.Method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Request", "true")
+	token := h.Token
+	if r.Token != "" {
+		token = r.Token
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if r.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", r.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	klog.V(5).Infof("vault request: %s %s", r.Method, u.Path)
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, &ConnectionError{Address: h.Address, TLS: isTLSError(err), Err: err}
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	klog.V(10).Infof("vault response %d: %s", resp.StatusCode, string(respBody))
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		ret := &ResponseError{
+			Method:     r.Method,
+			Path:       r.Path,
+			StatusCode: resp.StatusCode,
+		}
+		if err := json.Unmarshal(respBody, ret); err != nil || len(ret.Errors) == 0 {
+			ret.Errors = []string{strings.TrimSpace(string(respBody))}
+		}
+		return nil, ret
+	}
+	return respBody, nil
+}
+
+// CLITransport shells out to the vault binary. It is kept as a fallback for
+// environments that rely on CLI-only configuration.
+type CLITransport struct {
+	// TLS options are passed to the vault binary as flags
+	TLS TLSConfig
+}
+
+// Do runs the vault subcommand matching the method and returns its JSON output
+func (t CLITransport) Do(ctx context.Context, r *Request) ([]byte, error) {
+	var args []string
+	switch r.Method {
+	case http.MethodGet:
+		args = []string{"read", "-format=json"}
+	case MethodList:
+		args = []string{"list", "-format=json"}
+	case http.MethodDelete:
+		args = []string{"delete"}
+	default:
+		args = []string{"write", "-format=json"}
+		if len(r.Data) == 0 {
+			args = append(args, "-force")
+		}
+	}
+	if r.Namespace != "" {
+		args = append(args, fmt.Sprintf("-namespace=%s", r.Namespace))
+	}
+	args = append(args, t.TLS.cliArgs()...)
+	args = append(args, r.Path)
+	args = append(args, cliData(r.Data)...)
+
+	var env []string
+	if r.Token != "" {
+		env = append(env, fmt.Sprintf("VAULT_TOKEN=%s", r.Token))
+	}
+
+	out, output, err := execute(ctx, env, "vault", args...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, parseCLIError(r, output, err)
+	}
+	return out, nil
+}
+
+var (
+	cliCodeRegexp  = regexp.MustCompile(`Code: (\d+)\.`)
+	cliErrorRegexp = regexp.MustCompile(`(?m)^\s*\* (.+)$`)
+)
+
+// parseCLIError turns the output of a failed vault command into a ResponseError.
+// The vault CLI reports API errors as "Code: 403. Errors:" followed by one "* message" line per error.
+func parseCLIError(r *Request, output string, err error) error {
+	match := cliCodeRegexp.FindStringSubmatch(output)
+	if match == nil {
+		return err
+	}
+	ret := &ResponseError{
+		Method: r.Method,
+		Path:   r.Path,
+	}
+	ret.StatusCode, _ = strconv.Atoi(match[1])
+	for _, m := range cliErrorRegexp.FindAllStringSubmatch(output, -1) {
+		msg := strings.TrimSpace(m[1])
+		// skip the "1 error occurred:" header of multierrors
+		if strings.HasSuffix(msg, "occurred:") {
+			continue
+		}
+		ret.Errors = append(ret.Errors, msg)
+	}
+	return ret
+}
+
+// cliData converts request data to key=value arguments. Slices are passed as a repeated key,
+// which the vault CLI turns back into a list.
+func cliData(data map[string]interface{}) []string {
+	var args []string
+	for k, v := range data {
+		if list, ok := v.([]string); ok {
+			for _, item := range list {
+				args = append(args, fmt.Sprintf("%s=%s", k, item))
+			}
+			continue
+		}
+		args = append(args, fmt.Sprintf("%s=%v", k, v))
+	}
+	sort.Strings(args)
+	return args
+}
@@ -0,0 +1,149 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPTransport_Do(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		data      map[string]interface{}
+		status    int
+		response  string
+		wantPath  string
+		wantQuery string
+		wantBody  string
+		wantErr   bool
+	}{
+		{
+			name:     "write sends json body",
+			method:   http.MethodPost,
+			path:     "aws/sts/admin",
+			data:     map[string]interface{}{"ttl": "1h"},
+			status:   http.StatusOK,
+			response: `{"lease_id":"abc"}`,
+			wantPath: "/v1/aws/sts/admin",
+			wantBody: `{"ttl":"1h"}`,
+		},
+		{
+			name:      "read sends query parameters",
+			method:    http.MethodGet,
+			path:      "/azure/creds/admin",
+			data:      map[string]interface{}{"ttl": "1h"},
+			status:    http.StatusOK,
+			response:  `{}`,
+			wantPath:  "/v1/azure/creds/admin",
+			wantQuery: "ttl=1h",
+		},
+		{
+			name:     "error response",
+			method:   http.MethodGet,
+			path:     "azure/creds/admin",
+			status:   http.StatusForbidden,
+			response: `{"errors":["permission denied"]}`,
+			wantPath: "/v1/azure/creds/admin",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				assert.Equal(t, tt.method, r.Method)
+				assert.Equal(t, tt.wantPath, r.URL.Path)
+				assert.Equal(t, tt.wantQuery, r.URL.RawQuery)
+				assert.Equal(t, tt.wantBody, string(body))
+				assert.Equal(t, "token", r.Header.Get("X-Vault-Token"))
+				assert.Equal(t, "team/aws", r.Header.Get("X-Vault-Namespace"))
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			h := &HTTPTransport{Address: server.URL, Token: "token", Client: server.Client()}
+			got, err := h.Do(context.Background(), &Request{Method: tt.method, Path: tt.path, Data: tt.data, Namespace: "team/aws"})
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "permission denied")
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.response, string(got))
+			}
+		})
+	}
+}
+
+func TestCliData(t *testing.T) {
+	got := cliData(map[string]interface{}{
+		"ttl":   "1h",
+		"paths": []string{"a", "b"},
+	})
+	assert.Equal(t, []string{"paths=a", "paths=b", "ttl=1h"}, got)
+}
+
+// fakeResponse is a canned response replayed by fakeTransport
+type fakeResponse struct {
+	body string
+	err  error
+}
+
+// fakeTransport records every request and replays canned responses keyed by "METHOD path"
+type fakeTransport struct {
+	responses map[string]fakeResponse
+	requests  []*Request
+}
+
+func (f *fakeTransport) Do(ctx context.Context, req *Request) ([]byte, error) {
+	f.requests = append(f.requests, req)
+	resp, ok := f.responses[req.Method+" "+req.Path]
+	if !ok {
+		return nil, fmt.Errorf("%s %s returned status 404: no handler", req.Method, req.Path)
+	}
+	if resp.err != nil {
+		return nil, resp.err
+	}
+	return []byte(resp.body), nil
+}
+
+// stsResponse is the vault response of an aws/sts/admin request
+const stsResponse = `{"lease_id":"aws/sts/admin/123","lease_duration":3600,"data":{"access_key":"ASIA","secret_key":"secret","security_token":"token"}}`
+
+// newSTSConfig returns a config for the aws/admin role whose transport replays resp for sts requests
+func newSTSConfig(resp fakeResponse) (*Config, *fakeTransport) {
+	fake := &fakeTransport{responses: map[string]fakeResponse{
+		"POST aws/sts/admin": resp,
+	}}
+	c := NewConfig("aws", "admin", "aws", 30)
+	c.Transport = fake
+	return c, fake
+}
+
+// transportFunc adapts a function to the Transport interface
+type transportFunc func(ctx context.Context, req *Request) ([]byte, error)
+
+func (f transportFunc) Do(ctx context.Context, req *Request) ([]byte, error) {
+	return f(ctx, req)
+}
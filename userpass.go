@@ -0,0 +1,126 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// PasswordPrompter asks the user for a password. It can be replaced with a fake in tests.
+type PasswordPrompter interface {
+	PromptPassword(prompt string) (string, error)
+}
+
+// TerminalPrompter reads passwords from the terminal without echoing them
+type TerminalPrompter struct {
+	// Output is where the prompt is written. Defaults to os.Stderr.
+	Output io.Writer
+}
+
+// PromptPassword writes the prompt and reads a password from stdin with echo disabled
+func (p TerminalPrompter) PromptPassword(prompt string) (string, error) {
+	out := p.Output
+	if out == nil {
+		out = os.Stderr
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("cannot prompt for a password: stdin is not a terminal")
+	}
+
+	fmt.Fprint(out, prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(out)
+	if err != nil {
+		return "", fmt.Errorf("error reading password: %w", err)
+	}
+	return string(password), nil
+}
+
+// PasswordOptions configures a userpass or ldap login. The username is taken from Config.Username.
+type PasswordOptions struct {
+	// MountPath is the mount of the auth method. Defaults to userpass or ldap.
+	MountPath string
+	// Password skips prompting when it is not empty
+	Password string
+	// Prompter asks for the password. Defaults to a TerminalPrompter.
+	Prompter PasswordPrompter
+	// Persist stores the resulting token in ~/.vault-token
+	Persist bool
+}
+
+// UserpassLogin logs in to vault with the userpass auth method, prompting for the password
+func (c Config) UserpassLogin(opts PasswordOptions) (*Auth, error) {
+	return c.UserpassLoginContext(context.Background(), opts)
+}
+
+// UserpassLoginContext is like UserpassLogin but cancels the request to vault when the context is done
+func (c Config) UserpassLoginContext(ctx context.Context, opts PasswordOptions) (*Auth, error) {
+	return c.passwordLogin(ctx, "userpass", opts)
+}
+
+// LDAPLogin logs in to vault with the ldap auth method, prompting for the password
+func (c Config) LDAPLogin(opts PasswordOptions) (*Auth, error) {
+	return c.LDAPLoginContext(context.Background(), opts)
+}
+
+// LDAPLoginContext is like LDAPLogin but cancels the request to vault when the context is done
+func (c Config) LDAPLoginContext(ctx context.Context, opts PasswordOptions) (*Auth, error) {
+	return c.passwordLogin(ctx, "ldap", opts)
+}
+
+// passwordLogin logs in to an auth method that takes a username in the path and a password in the body
+func (c Config) passwordLogin(ctx context.Context, method string, opts PasswordOptions) (*Auth, error) {
+	if c.Username == "" {
+		return nil, fmt.Errorf("a username is required for %s login", method)
+	}
+
+	password := opts.Password
+	if password == "" {
+		prompter := opts.Prompter
+		if prompter == nil {
+			prompter = TerminalPrompter{}
+		}
+		var err error
+		password, err = prompter.PromptPassword(fmt.Sprintf("Password (will be hidden) for %s: ", c.Username))
+		if err != nil {
+			return nil, err
+		}
+		if password == "" {
+			return nil, fmt.Errorf("no password provided")
+		}
+	}
+
+	path := fmt.Sprintf("%s/login/%s", mountPath(opts.MountPath, method), url.PathEscape(c.Username))
+	auth, err := c.login(ctx, path, map[string]interface{}{
+		"password": password,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Persist {
+		if err := PersistToken(auth.ClientToken); err != nil {
+			return nil, err
+		}
+	}
+	return auth, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePrompter returns a fixed password and records the prompt
+type fakePrompter struct {
+	password string
+	err      error
+	prompt   string
+}
+
+func (f *fakePrompter) PromptPassword(prompt string) (string, error) {
+	f.prompt = prompt
+	return f.password, f.err
+}
+
+func TestConfig_passwordLogin(t *testing.T) {
+	tests := []struct {
+		name     string
+		ldap     bool
+		username string
+		opts     PasswordOptions
+		prompter *fakePrompter
+		wantPath string
+		wantErr  bool
+	}{
+		{
+			name:     "userpass prompts",
+			username: "jane",
+			prompter: &fakePrompter{password: "hunter2"},
+			wantPath: "auth/userpass/login/jane",
+		},
+		{
+			name:     "ldap custom mount",
+			ldap:     true,
+			username: "jane@example.com",
+			opts:     PasswordOptions{MountPath: "corp-ldap"},
+			prompter: &fakePrompter{password: "hunter2"},
+			wantPath: "auth/corp-ldap/login/jane@example.com",
+		},
+		{
+			name:     "password provided",
+			ldap:     true,
+			username: "jane",
+			opts:     PasswordOptions{Password: "hunter2"},
+			prompter: &fakePrompter{err: fmt.Errorf("should not prompt")},
+			wantPath: "auth/ldap/login/jane",
+		},
+		{
+			name:     "no username",
+			prompter: &fakePrompter{password: "hunter2"},
+			wantErr:  true,
+		},
+		{
+			name:     "prompt failed",
+			username: "jane",
+			prompter: &fakePrompter{err: fmt.Errorf("stdin is not a terminal")},
+			wantErr:  true,
+		},
+		{
+			name:     "empty password",
+			username: "jane",
+			prompter: &fakePrompter{},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeTransport{responses: map[string]fakeResponse{
+				"POST " + tt.wantPath: {body: `{"auth":{"client_token":"s.ldap","policies":["default","admins"]}}`},
+			}}
+			c := Config{Username: tt.username, Transport: fake}
+			opts := tt.opts
+			opts.Prompter = tt.prompter
+
+			var auth *Auth
+			var err error
+			if tt.ldap {
+				auth, err = c.LDAPLogin(opts)
+			} else {
+				auth, err = c.UserpassLogin(opts)
+			}
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Len(t, fake.requests, 0)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "s.ldap", auth.ClientToken)
+			assert.Equal(t, []string{"default", "admins"}, auth.Policies)
+			assert.Equal(t, tt.wantPath, fake.requests[0].Path)
+			assert.Equal(t, map[string]interface{}{"password": "hunter2"}, fake.requests[0].Data)
+			if tt.opts.Password == "" {
+				assert.Equal(t, fmt.Sprintf("Password (will be hidden) for %s: ", tt.username), tt.prompter.prompt)
+			}
+		})
+	}
+}
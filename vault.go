@@ -16,45 +16,137 @@ package vaultutil
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"k8s.io/klog"
 )
 
 // Token is the response of vault token lookup
 type Token struct {
-	Data struct {
-		TTL int `json:"ttl"`
-	} `json:"data"`
+	Data TokenData `json:"data"`
 }
 
-// CheckToken makes sure we have a valid token
-func CheckToken() error {
-	data, _, err := execute("vault", "token", "lookup", "-format=json")
+// TokenData is the metadata of a vault token
+type TokenData struct {
+	// Accessor is the accessor of the token
+	Accessor string `json:"accessor"`
+	// DisplayName is the name given to the token by the auth method
+	DisplayName string `json:"display_name"`
+	// EntityID is the identity entity of the token
+	EntityID string `json:"entity_id"`
+	// Path is the login path that created the token
+	Path string `json:"path"`
+	// Policies are the policies attached to the token
+	Policies []string `json:"policies"`
+	// Meta is the metadata set by the auth method
+	Meta map[string]string `json:"meta"`
+	// IssueTime is the time the token was created
+	IssueTime time.Time `json:"issue_time"`
+	// ExpireTime is the time the token expires. It is nil for tokens that do not expire.
+	ExpireTime *time.Time `json:"expire_time"`
+	// TTL is the number of seconds until the token expires. It is zero for tokens that do not expire.
+	TTL int `json:"ttl"`
+	// CreationTTL is the TTL the token was created with
+	CreationTTL int64 `json:"creation_ttl"`
+	// ExplicitMaxTTL is the maximum TTL of the token, or zero if it has none
+	ExplicitMaxTTL int64 `json:"explicit_max_ttl"`
+	// Period is the renewal period of a periodic token, or zero
+	Period int64 `json:"period"`
+	// Renewable is true if the token can be renewed
+	Renewable bool `json:"renewable"`
+	// Orphan is true if the token has no parent
+	Orphan bool `json:"orphan"`
+	// NumUses is the number of uses left, or zero if the token is not limited
+	NumUses int `json:"num_uses"`
+	// Type is either service or batch
+	Type string `json:"type"`
+}
+
+// NonExpiring returns true for tokens without a TTL, such as root tokens
+func (t *Token) NonExpiring() bool {
+	return t.Data.TTL == 0 && t.Data.ExpireTime == nil
+}
+
+// LookupToken returns the metadata of the token used by the config
+func (c Config) LookupToken() (*Token, error) {
+	return c.LookupTokenContext(context.Background())
+}
+
+// LookupTokenContext is like LookupToken but cancels the lookup when the context is done
+func (c Config) LookupTokenContext(ctx context.Context) (*Token, error) {
+	data, err := c.idempotentRequest(ctx, http.MethodGet, "auth/token/lookup-self", nil)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("vault token lookup failed: %w", err)
 	}
 
 	token := &Token{}
 	err = json.Unmarshal(data, token)
 	if err != nil {
-		return fmt.Errorf("error unmarshaling vault token: %s", err.Error())
+		return nil, fmt.Errorf("error unmarshaling vault token: %s", err.Error())
+	}
+	return token, nil
+}
+
+// CheckToken makes sure we have a valid token
+func CheckToken() error {
+	return Config{}.CheckToken()
+}
+
+// CheckToken makes sure the token used by the config is valid, and will not expire
+// within the MinTokenTTL of the config. Tokens that do not expire are always valid.
+func (c Config) CheckToken() error {
+	return c.CheckTokenContext(context.Background())
+}
+
+// CheckTokenContext is like CheckToken but cancels the lookup when the context is done
+func (c Config) CheckTokenContext(ctx context.Context) error {
+	token, err := c.LookupTokenContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if token.NonExpiring() {
+		klog.V(3).Infof("vault token does not expire")
+		return nil
 	}
 
-	if token.Data.TTL < 30 {
-		return fmt.Errorf("vault token will expire in less than 30 seconds")
+	minTTL := c.MinTokenTTL
+	if minTTL == 0 {
+		minTTL = DefaultMinTokenTTL
+	}
+	if int64(token.Data.TTL) < minTTL {
+		return fmt.Errorf("%w: less than %d seconds remaining", ErrTokenExpiring, minTTL)
 	}
 	return nil
 }
 
-// Login initiates a vault login with the provided method
+// Login initiates a vault login with the provided method.
+// Interactive logins are handled by the vault binary, which must be installed.
 func Login(loginMethod string) error {
-	_, _, err := executeInteractive(true, true, "vault", "login", "-method", loginMethod)
+	return Config{}.Login(loginMethod)
+}
+
+// Login initiates a vault login with the provided method in the config's namespace
+func (c Config) Login(loginMethod string) error {
+	return c.LoginContext(context.Background(), loginMethod)
+}
+
+// LoginContext is like Login but kills the vault login command when the context is done.
+// The config's Timeout is not applied, since the user may need time to complete the login.
+func (c Config) LoginContext(ctx context.Context, loginMethod string) error {
+	args := []string{"login", "-method", loginMethod}
+	if ns := c.namespace(); ns != "" {
+		args = append(args, fmt.Sprintf("-namespace=%s", ns))
+	}
+	_, _, err := executeInteractive(ctx, true, true, "vault", args...)
 	if err != nil {
 		return err
 	}
@@ -63,21 +155,60 @@ func Login(loginMethod string) error {
 
 // revokeLease revokes a vault lease.
 // Utilized by individual credential Revoke() functions
-func revokeLease(leaseID string) error {
-	_, _, err := execute("vault", "lease", "revoke", leaseID)
+func (c Config) revokeLease(ctx context.Context, leaseID string) error {
+	_, err := c.idempotentRequest(ctx, http.MethodPut, revokePath, map[string]interface{}{
+		"lease_id": leaseID,
+	})
 	if err != nil {
-		return err
+		return fmt.Errorf("vault lease revoke failed: %w", err)
 	}
 	return nil
 }
 
+// leaseRenewal is the response of vault to a lease renewal
+type leaseRenewal struct {
+	LeaseID       string `json:"lease_id"`
+	LeaseDuration int64  `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+// renewLease extends a vault lease by increment seconds, or by the default of the secrets
+// engine when increment is zero. Utilized by individual credential Renew() functions
+func (c Config) renewLease(ctx context.Context, leaseID string, increment int64) (*leaseRenewal, error) {
+	if leaseID == "" {
+		return nil, fmt.Errorf("vault lease renew failed: lease id was empty")
+	}
+	params := map[string]interface{}{
+		"lease_id": leaseID,
+	}
+	if increment > 0 {
+		params["increment"] = increment
+	}
+
+	// renewing a lease again only moves its expiration, so it can safely be retried
+	data, err := c.idempotentRequest(ctx, http.MethodPut, renewPath, params)
+	if err != nil {
+		return nil, fmt.Errorf("vault lease renew failed: %w", err)
+	}
+
+	ret := &leaseRenewal{}
+	if err := json.Unmarshal(data, ret); err != nil {
+		return nil, fmt.Errorf("error unmarshaling vault lease: %s", err.Error())
+	}
+	return ret, nil
+}
+
 // execute returns the output and error of a command run using inventory environment variables.
-func execute(name string, arg ...string) ([]byte, string, error) {
-	cmd := exec.Command(name, arg...)
+// Any env entries are added to the environment of the current process.
+func execute(ctx context.Context, env []string, name string, arg ...string) ([]byte, string, error) {
+	cmd := exec.CommandContext(ctx, name, arg...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	data, err := cmd.CombinedOutput()
 	output := strings.TrimSpace(string(data))
 	if err != nil {
-		return nil, "", fmt.Errorf("exit code %d running command %s: %s", cmd.ProcessState.ExitCode(), cmd.String(), output)
+		return nil, output, fmt.Errorf("exit code %d running command %s: %s", cmd.ProcessState.ExitCode(), cmd.String(), output)
 	}
 	klog.V(5).Infof("command %s output: %s", cmd.String(), output)
 	return data, output, nil
@@ -85,9 +216,9 @@ func execute(name string, arg ...string) ([]byte, string, error) {
 
 // executeInteractive works like exec, but allows interactivity with the command
 // https://blog.kowalczyk.info/article/wOYk/advanced-command-execution-in-go-with-osexec.html
-func executeInteractive(showStdErr bool, showStdOut bool, name string, arg ...string) ([]byte, string, error) {
+func executeInteractive(ctx context.Context, showStdErr bool, showStdOut bool, name string, arg ...string) ([]byte, string, error) {
 
-	cmd := exec.Command(name, arg...)
+	cmd := exec.CommandContext(ctx, name, arg...)
 
 	var stdoutBuf, stderrBuf bytes.Buffer
 
@@ -0,0 +1,106 @@
+// Copyright 2020 Fairwinds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultutil
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_CheckToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		minTTL   int64
+		response fakeResponse
+		wantErr  bool
+	}{
+		{
+			name:     "valid",
+			response: fakeResponse{body: `{"data":{"ttl":3600}}`},
+		},
+		{
+			name:     "about to expire",
+			response: fakeResponse{body: `{"data":{"ttl":10}}`},
+			wantErr:  true,
+		},
+		{
+			name:     "root token does not expire",
+			response: fakeResponse{body: `{"data":{"ttl":0,"expire_time":null,"policies":["root"]}}`},
+		},
+		{
+			name:     "expired",
+			response: fakeResponse{body: `{"data":{"ttl":0,"expire_time":"2020-01-01T00:00:00Z"}}`},
+			wantErr:  true,
+		},
+		{
+			name:     "below configured minimum",
+			minTTL:   600,
+			response: fakeResponse{body: `{"data":{"ttl":300}}`},
+			wantErr:  true,
+		},
+		{
+			name:     "lookup failed",
+			response: fakeResponse{err: fmt.Errorf("permission denied")},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{MinTokenTTL: tt.minTTL, Transport: &fakeTransport{responses: map[string]fakeResponse{
+				"GET auth/token/lookup-self": tt.response,
+			}}}
+			err := c.CheckToken()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestConfig_LookupToken(t *testing.T) {
+	c := Config{Transport: &fakeTransport{responses: map[string]fakeResponse{
+		"GET auth/token/lookup-self": {body: `{
+			"data": {
+				"accessor": "acc",
+				"display_name": "ldap-jane",
+				"entity_id": "ent",
+				"expire_time": "2030-01-02T03:04:05.123456Z",
+				"orphan": true,
+				"period": 3600,
+				"policies": ["default", "admins"],
+				"renewable": true,
+				"ttl": 1800,
+				"type": "service"
+			}
+		}`},
+	}}}
+
+	token, err := c.LookupToken()
+	assert.NoError(t, err)
+	assert.Equal(t, "ldap-jane", token.Data.DisplayName)
+	assert.Equal(t, "ent", token.Data.EntityID)
+	assert.Equal(t, []string{"default", "admins"}, token.Data.Policies)
+	assert.Equal(t, time.Date(2030, 1, 2, 3, 4, 5, 123456000, time.UTC), token.Data.ExpireTime.UTC())
+	assert.True(t, token.Data.Renewable)
+	assert.True(t, token.Data.Orphan)
+	assert.Equal(t, int64(3600), token.Data.Period)
+	assert.Equal(t, "service", token.Data.Type)
+	assert.False(t, token.NonExpiring())
+}